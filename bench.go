@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// defaultBenchRuns specifies the default number of times dependencies are resolved by the
+	// bench command for each configuration.
+	defaultBenchRuns = 5
+)
+
+// benchRuns holds the number of times dependencies are resolved by the bench command for each
+// configuration.
+var benchRuns int
+
+// benchCmd defines the command that measures the time taken to resolve the dependencies of the
+// monitored packages.
+var benchCmd = &cobra.Command{
+	Use:   "bench [flags] [path]",
+	Short: "Measure the time taken to resolve the dependencies of the monitored packages.",
+	Long: `Resolves the dependencies of the packages that would be monitored for PATH several times, both with and without external dependencies, and reports the number of files found along with the minimum, average and maximum time taken.  Neither files are watched nor commands run.  This helps gauge the cost of starting godepmon and of every rerun, and whether flags such as --fast are worth enabling.
+
+If PATH is not specified, the current working directory is assumed.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  runBench,
+}
+
+// init registers the bench command with the root command.
+func init() {
+	benchCmd.Flags().IntVarP(&benchRuns, "runs", "n", defaultBenchRuns,
+		"Number of times dependencies are resolved for each configuration")
+	rootCmd.AddCommand(benchCmd)
+}
+
+// runBench is the main execution logic of the bench command.
+func runBench(cmd *cobra.Command, args []string) {
+	path := "."
+	if len(args) > 0 {
+		path = args[0]
+	}
+	if benchRuns < 1 {
+		Fatal("--runs must be at least 1")
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "EXTERNAL DEPS\tFILES\tMIN\tAVG\tMAX")
+	for _, external := range []bool{false, true} {
+		walker := newDepWalkerWithExternal(external)
+
+		var files int
+		var total, min, max time.Duration
+		for i := 0; i < benchRuns; i++ {
+			start := time.Now()
+			deps, err := walker.List(path)
+			if err != nil {
+				Fatal("Failed to determine dependencies\n%v", err)
+			}
+			elapsed := time.Since(start)
+
+			files = len(deps)
+			total += elapsed
+			if i == 0 || elapsed < min {
+				min = elapsed
+			}
+			if elapsed > max {
+				max = elapsed
+			}
+		}
+
+		fmt.Fprintf(tw, "%t\t%d\t%s\t%s\t%s\n", external, files, roundDuration(min),
+			roundDuration(total/time.Duration(benchRuns)), roundDuration(max))
+	}
+	tw.Flush()
+}
+
+// roundDuration rounds a duration to a precision suitable for reporting resolution times.
+func roundDuration(d time.Duration) time.Duration {
+	return d.Round(100 * time.Microsecond)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// BuildError represents an error that occurs when building the package of the command fails.
+type BuildError struct {
+	Dir string
+	Err error
+}
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("Failed to build package in %s\n%v", e.Dir, e.Err)
+}
+
+// hashBuild builds the package in the given directory to a temporary binary and returns the
+// SHA-256 hash of the binary.  The build is made as reproducible as possible by trimming file
+// system paths and omitting the build ID, so that the hash only changes when the compiled program
+// does.  The compiler's output is forwarded to godepmon's standard output and error streams.
+func hashBuild(ctx context.Context, dir string) (string, error) {
+	tmp, err := os.MkdirTemp("", "godepmon-build-")
+	if err != nil {
+		return "", &BuildError{Dir: dir, Err: err}
+	}
+	defer os.RemoveAll(tmp)
+
+	bin := filepath.Join(tmp, "bin")
+	cmd := exec.CommandContext(ctx, "go", "build", "-trimpath", "-ldflags=-buildid=", "-o", bin, ".")
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	log.Debug().Msgf("building package to compare build output: %s", cmd)
+	if err := cmd.Run(); err != nil {
+		return "", &BuildError{Dir: dir, Err: err}
+	}
+
+	hash, err := hashFile(bin)
+	if err != nil {
+		return "", &BuildError{Dir: dir, Err: err}
+	}
+
+	return hash, nil
+}
+
+// hashFile returns the hex-encoded SHA-256 hash of the content of the file at the given path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CleanRunError represents an error that occurs when preparing the copy of the source tree in which
+// the command runs with --clean-run.
+type CleanRunError struct {
+	Root string
+	Err  error
+}
+
+func (e *CleanRunError) Error() string {
+	return fmt.Sprintf("Failed to copy '%s' for a clean run\n%v", e.Root, e.Err)
+}
+
+// cleanRunSkipDirs lists the names of the directories that are not copied for a clean run, since
+// they are of no use to the command and may be large.
+var cleanRunSkipDirs = []string{".git"}
+
+// copyTree copies the directory tree rooted at src to dst, which must exist.  Directories listed in
+// skip are left out along with their subtree.  Symbolic links are recreated as they are, and other
+// files that are neither regular files nor directories are ignored.
+func copyTree(src string, dst string, skip []string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case d.IsDir():
+			if path == src {
+				return nil
+			}
+			for _, name := range skip {
+				if d.Name() == name {
+					return fs.SkipDir
+				}
+			}
+			return os.Mkdir(target, 0755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(path, target)
+		default:
+			log.Trace().Msgf("not copying irregular file: %s", path)
+			return nil
+		}
+	})
+}
+
+// copyFile copies the content and permissions of the regular file at src to a new file at dst.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,34 @@
+package main
+
+import "time"
+
+// clock abstracts the current time and the scheduling of delayed functions so that time-dependent
+// logic, such as event debouncing, can be driven deterministically.
+type clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// AfterFunc waits for the duration to elapse and then calls f in its own goroutine.  It
+	// returns a timer that can be used to cancel the call.
+	AfterFunc(d time.Duration, f func()) timer
+}
+
+// timer represents a single scheduled function call, as returned by clock.AfterFunc.
+type timer interface {
+	// Stop prevents the timer from firing.  It returns false if the timer has already fired or
+	// been stopped.
+	Stop() bool
+}
+
+// realClock implements clock using the time package.
+type realClock struct{}
+
+// Now calls time.Now.
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// AfterFunc calls time.AfterFunc.
+func (realClock) AfterFunc(d time.Duration, f func()) timer {
+	return time.AfterFunc(d, f)
+}
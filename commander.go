@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+	"unicode"
 
 	"github.com/rs/zerolog/log"
 )
@@ -16,6 +22,17 @@ const (
 	// defaultTerminationTimeout specifies the default timeout duration for the termination of
 	// the command process via SIGTERM signalling.
 	defaultTerminationTimeout = 250 * time.Millisecond
+
+	// outputDrainTimeout specifies how long the output of a terminated command is given to drain
+	// before the processes it left behind, which keep its output open, are killed.
+	outputDrainTimeout = 200 * time.Millisecond
+
+	// resetAttributes is the escape sequence that resets the text attributes of a terminal.
+	resetAttributes = "\x1b[0m"
+
+	// changedFileEnv names the environment variable through which the command is given the path of
+	// the file listing the changed paths.
+	changedFileEnv = "GODEPMON_CHANGED_FILE"
 )
 
 // EmptyCommandError represents an error that occurs when an attempt is made to start a commander
@@ -36,6 +53,38 @@ func (e *StartCommandError) Error() string {
 	return fmt.Sprintf("Failed to start command '%s'\n%v", e.Command, e.Err)
 }
 
+// CommandNotFoundError represents an error that occurs when the program of the command cannot be
+// found.
+type CommandNotFoundError struct {
+	Name string
+	Err  error
+}
+
+func (e *CommandNotFoundError) Error() string {
+	return fmt.Sprintf("Command not found: %s\n%v", e.Name, e.Err)
+}
+
+// UnterminatedQuoteError represents an error that occurs when a command string contains a quote
+// that is not closed.
+type UnterminatedQuoteError struct {
+	Command string
+}
+
+func (e *UnterminatedQuoteError) Error() string {
+	return fmt.Sprintf("Unterminated quote in command '%s'", e.Command)
+}
+
+// ArgsFileError represents an error that occurs when the file holding the command's arguments
+// cannot be read.
+type ArgsFileError struct {
+	Path string
+	Err  error
+}
+
+func (e *ArgsFileError) Error() string {
+	return fmt.Sprintf("Failed to read command arguments file '%s'\n%v", e.Path, e.Err)
+}
+
 // ForceKillError represents an error that occurs when force-killing the process group fails.
 type ForceKillError struct {
 	Pid int
@@ -46,6 +95,71 @@ func (e *ForceKillError) Error() string {
 	return fmt.Sprintf("Error force-killing the process group (PID %d)\n%v", e.Pid, e.Err)
 }
 
+// NotRunningError represents an error that occurs when signalling the command while it is not
+// running.
+type NotRunningError struct{}
+
+func (e *NotRunningError) Error() string {
+	return "Command is not running"
+}
+
+// SignalCommandError represents an error that occurs when sending a signal to the process group of
+// the command fails.
+type SignalCommandError struct {
+	Pid    int
+	Signal syscall.Signal
+	Err    error
+}
+
+func (e *SignalCommandError) Error() string {
+	return fmt.Sprintf("Error sending %s to the process group (PID %d)\n%v", e.Signal, e.Pid, e.Err)
+}
+
+// ChangedFileError represents an error that occurs when the file listing the changed paths cannot be
+// written.
+type ChangedFileError struct {
+	Err error
+}
+
+func (e *ChangedFileError) Error() string {
+	return fmt.Sprintf("Failed to write the list of changed files\n%v", e.Err)
+}
+
+// OutputGlobError represents an error that occurs when an output glob is malformed.
+type OutputGlobError struct {
+	Glob string
+}
+
+func (e *OutputGlobError) Error() string {
+	return fmt.Sprintf("Invalid output glob '%s'", e.Glob)
+}
+
+// ValidateOutputGlobs returns an error if any of the given globs is malformed.
+func ValidateOutputGlobs(globs []string) error {
+	for _, glob := range globs {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return &OutputGlobError{Glob: glob}
+		}
+	}
+	return nil
+}
+
+// RunStats summarizes the outcome of the runs of a command.
+type RunStats struct {
+	// Runs is the number of times the command was started.
+	Runs int
+	// Passed is the number of runs that exited of their own accord with a zero status.
+	Passed int
+	// Failed is the number of runs that exited of their own accord with a non-zero status.
+	Failed int
+	// Terminated is the number of runs terminated by the commander, e.g. to restart the command.
+	Terminated int
+	// LastTerminated reports whether the last run that ended was terminated by the commander.
+	LastTerminated bool
+	// LastExitCode is the exit code of the last run that exited of its own accord.
+	LastExitCode int
+}
+
 // commanderOption defines a function signature for options that can be passed to NewCommander to
 // configure a commander instance.
 type commanderOption func(c *commander)
@@ -54,16 +168,53 @@ type commanderOption func(c *commander)
 // commands.
 type commander struct {
 	terminationTimeout time.Duration
+	killSequence       []killStep
 	cwd                string
 	command            string
+	stdin              *string
+	pipe               string
+	pty                bool
+	argsFile           string
+	shell              string
+	envFile            string
+	limits             resourceLimits
+	cleanRoot          string
+	logOriginal        bool
+	outputs            []string
+	changedFile        bool
+	changes            []string
+	tail               *tailBuffer
+	cleanDir           string
 	cmd                *exec.Cmd
+	stop               chan struct{}
+	exited             chan struct{}
 	mu                 sync.Mutex
+
+	// Exit status bookkeeping is guarded by its own mutex since it is updated by the goroutine
+	// waiting on the command, which must not contend with Terminate holding the main mutex.
+	statusMu    sync.Mutex
+	terminating bool
+	exitCode    int
+	running     bool
+	exitedAt    time.Time
+	stats       RunStats
 }
 
-// NewCommander creates a new commander instance with the specified working directory and
-// command. It returns a pointer to the created commander instance.
-func NewCommander(cwd string, command string) *commander {
-	return &commander{terminationTimeout: defaultTerminationTimeout, cwd: cwd, command: command}
+// NewCommander creates a new commander instance with the specified working directory, command and
+// options. It returns a pointer to the created commander instance.
+func NewCommander(cwd string, command string, options ...commanderOption) *commander {
+	c := &commander{
+		terminationTimeout: defaultTerminationTimeout,
+		killSequence:       []killStep{{Signal: syscall.SIGTERM}},
+		cwd:                cwd,
+		command:            command,
+	}
+
+	for _, setopt := range options {
+		setopt(c)
+	}
+
+	return c
 }
 
 // WithTerminationTimeout is an option function for NewCommander that configures a custom
@@ -74,36 +225,701 @@ func WithTerminationTimeout(timeout time.Duration) commanderOption {
 	}
 }
 
+// WithKillSequence is an option function for NewCommander that configures the signals sent in turn
+// to the process group of the command to terminate it, escalating to the next signal if the command
+// does not exit in time.  The process group is force-killed if the sequence does not end with
+// SIGKILL.
+func WithKillSequence(steps []killStep) commanderOption {
+	return func(c *commander) {
+		if len(steps) > 0 {
+			c.killSequence = steps
+		}
+	}
+}
+
+// WithStdin is an option function for NewCommander that configures fixed content to be written to
+// the command's standard input every time it is started.  Standard input is closed once the content
+// has been written.
+func WithStdin(content string) commanderOption {
+	return func(c *commander) {
+		c.stdin = &content
+	}
+}
+
+// WithPipe is an option function for NewCommander that configures a filter command through which
+// the combined standard output and standard error of the command are piped.  The filter's own output
+// goes to the terminal.  The filter is started along with every run of the command and exits once
+// the command's output reaches end of file.
+func WithPipe(filter string) commanderOption {
+	return func(c *commander) {
+		c.pipe = filter
+	}
+}
+
+// WithTailOnFail is an option function for NewCommander that configures the number of lines at the
+// end of the command's output that are printed again, following a header, when the command exits
+// with a non-zero status.  The output then passes through godepmon.  Zero disables the feature.
+func WithTailOnFail(lines int) commanderOption {
+	return func(c *commander) {
+		if lines > 0 {
+			c.tail = newTailBuffer(lines)
+		}
+	}
+}
+
+// WithPTY is an option function for NewCommander that configures whether the command is attached
+// to a pseudo-terminal, so that it behaves as when run from a terminal.  The command runs without
+// one if it cannot be allocated.
+func WithPTY(pty bool) commanderOption {
+	return func(c *commander) {
+		c.pty = pty
+	}
+}
+
+// WithArgsFile is an option function for NewCommander that configures a file holding the program
+// and arguments of the command, one per line, in place of the command string.  The file is read
+// every time the command is started, and its lines are used verbatim so that arguments may contain
+// spaces.
+func WithArgsFile(path string) commanderOption {
+	return func(c *commander) {
+		c.argsFile = path
+	}
+}
+
+// WithShell is an option function for NewCommander that configures a shell through which the
+// command string is run, as "shell -c command", so that it may use pipes, redirections and other
+// shell syntax.  An empty path runs the command directly.  The shell has no effect if the command is
+// read from an arguments file.
+func WithShell(path string) commanderOption {
+	return func(c *commander) {
+		c.shell = path
+	}
+}
+
+// WithEnvFile is an option function for NewCommander that configures a dotenv file whose variables
+// are added to the environment of the command, overriding those inherited from godepmon.  The file
+// is read every time the command is started, so that changes to it take effect on the next run.
+func WithEnvFile(path string) commanderOption {
+	return func(c *commander) {
+		c.envFile = path
+	}
+}
+
+// WithLimits is an option function for NewCommander that configures the resource limits applied to
+// the command as soon as it starts, which the processes it starts inherit.  Limits are only
+// supported on Linux.
+func WithLimits(limits resourceLimits) commanderOption {
+	return func(c *commander) {
+		c.limits = limits
+	}
+}
+
+// WithCleanRun is an option function for NewCommander that configures the root of a source tree
+// that is copied to a fresh temporary directory before every start of the command.  The command
+// then runs in the copy, in the directory corresponding to its working directory, so that the
+// artifacts it produces do not pollute the source tree.  The working directory must reside beneath
+// the root.
+func WithCleanRun(root string) commanderOption {
+	return func(c *commander) {
+		c.cleanRoot = root
+	}
+}
+
+// WithLogOriginal is an option function for NewCommander that configures whether the command is
+// logged as given when started, rather than with the resolved path of its program.  The resolved
+// command is then logged at debug level.
+func WithLogOriginal(original bool) commanderOption {
+	return func(c *commander) {
+		c.logOriginal = original
+	}
+}
+
+// WithOutputs is an option function for NewCommander that declares globs, relative to the working
+// directory, matching the files the command is expected to produce, such as "coverage.out" or
+// "dist".  A glob matching a directory covers all files beneath it.
+func WithOutputs(globs []string) commanderOption {
+	return func(c *commander) {
+		c.outputs = globs
+	}
+}
+
+// WithChangedFile is an option function for NewCommander that configures whether the paths whose
+// changes led to the command being started are written, one per line, to a temporary file whose
+// path is given to the command in the GODEPMON_CHANGED_FILE environment variable.  The file is empty
+// on the first run, and is removed once the command exits.
+func WithChangedFile(enabled bool) commanderOption {
+	return func(c *commander) {
+		c.changedFile = enabled
+	}
+}
+
 // Start initiates the execution of the commander's command. It locks the commander instance,
-// prepares the command for execution, and starts it. An error is returned if the command fails to
-// start.
-func (c *commander) Start() error {
+// prepares the command for execution, and starts it. The command is terminated should the context
+// be cancelled while it is running. An error is returned if the command fails to start.
+func (c *commander) Start(ctx context.Context) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	args := strings.Fields(c.command)
-	if len(args) == 0 {
-		return &EmptyCommandError{}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	args, err := c.argv()
+	if err != nil {
+		return err
+	}
+
+	dir := c.cwd
+	if c.cleanRoot != "" {
+		if dir, err = c.prepareCleanDir(); err != nil {
+			return err
+		}
 	}
 
 	c.cmd = exec.Command(args[0], args[1:]...)
-	c.cmd.Dir = c.cwd
-	c.cmd.Stdout = os.Stdout
-	c.cmd.Stderr = os.Stderr
+	c.cmd.Dir = dir
+
+	if c.envFile != "" {
+		env, err := readEnvFile(c.envFile)
+		if err != nil {
+			return err
+		}
+		c.cmd.Env = append(os.Environ(), env...)
+	}
+
+	var changedFile string
+	if c.changedFile {
+		if changedFile, err = writeChangedFile(c.changes); err != nil {
+			return err
+		}
+		if c.cmd.Env == nil {
+			c.cmd.Env = os.Environ()
+		}
+		c.cmd.Env = append(c.cmd.Env, changedFileEnv+"="+changedFile)
+	}
+	c.cmd.Stdout = c.teeOutput(os.Stdout)
+	c.cmd.Stderr = c.teeOutput(os.Stderr)
 	c.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if c.tail != nil {
+		c.tail.Reset()
+		// Output copied by godepmon must not hold up waiting for the command should processes
+		// it left behind keep the output open.
+		c.cmd.WaitDelay = c.terminationTimeout
+	}
+
+	var stdin io.WriteCloser
+	if c.stdin != nil {
+		if stdin, err = c.cmd.StdinPipe(); err != nil {
+			removeChangedFile(changedFile)
+			return &StartCommandError{Command: c.command, Err: err}
+		}
+	}
+
+	var filter *exec.Cmd
+	var output *os.File
+	if c.pipe != "" {
+		if filter, output, err = c.startFilter(); err != nil {
+			removeChangedFile(changedFile)
+			return err
+		}
+		c.cmd.Stdout = output
+		c.cmd.Stderr = output
+	}
+
+	var term *terminal
+	if c.pty {
+		if term, err = openTerminal(); err != nil {
+			log.Warn().Msgf("unable to allocate pseudo-terminal, running program without: %v", err)
+		} else {
+			term.attach(c.cmd)
+		}
+	}
+
+	if c.logOriginal {
+		log.Info().Msgf("running program: %s", c.command)
+		log.Debug().Msgf("resolved program: %s", c.cmd)
+	} else {
+		log.Info().Msgf("running program: %s", c.cmd)
+	}
+	if !c.limits.isZero() {
+		if err := limitCommand(c.cmd, c.limits); err != nil {
+			log.Warn().Msgf("unable to apply resource limits to program: %v", err)
+		}
+	}
+	err = c.cmd.Start()
 
-	log.Info().Msgf("running program: %s", c.cmd)
-	if err := c.cmd.Start(); err != nil {
+	// The command and the filter hold their own copies of the pipe's ends, which must be released
+	// here so that the filter receives end of file once the command exits.  With a terminal, the
+	// pipe's write end is instead held until the terminal's output has been relayed.
+	if filter != nil {
+		filter.Stdin.(*os.File).Close()
+	}
+	if term != nil {
+		if output != nil {
+			term.relay(output, output)
+		} else {
+			term.relay(c.teeOutput(os.Stdout), nil)
+		}
+	} else if output != nil {
+		output.Close()
+	}
+
+	if err != nil {
+		if term != nil {
+			term.close(c.terminationTimeout)
+		}
+		if filter != nil {
+			c.waitFilter(filter, 0)
+		}
+		removeChangedFile(changedFile)
 		return &StartCommandError{Command: c.command, Err: err}
 	}
 
+	if stdin != nil {
+		// Written asynchronously since the child may not consume its input before the pipe's
+		// buffer fills up.
+		go writeStdin(stdin, *c.stdin)
+	}
+
 	log.Info().Msgf("program running (PID %d)", c.cmd.Process.Pid)
+	c.statusMu.Lock()
+	c.terminating = false
+	c.running = true
+	c.stats.Runs++
+	c.statusMu.Unlock()
+
+	c.exited = make(chan struct{})
+	go c.wait(c.cmd, filter, term, changedFile, c.exited)
+
+	c.stop = make(chan struct{})
+	go c.terminateOnCancel(ctx, c.stop)
+	return nil
+}
+
+// SetCommand replaces the command string, taking effect the next time the command is started.  It
+// has no effect if the command is read from an arguments file.
+func (c *commander) SetCommand(command string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.command = command
+}
+
+// Command returns the command string.
+func (c *commander) Command() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.command
+}
+
+// SetChanges sets the paths whose changes led to the command being started next, which are listed
+// in the file given to the command if so configured.
+func (c *commander) SetChanges(paths []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changes = paths
+}
+
+// Preflight checks that the program of the command can be found, and that its environment file, if
+// any, is valid, so that mistakes are reported before monitoring starts.  Relative paths to the
+// program are resolved against the command's working directory.
+func (c *commander) Preflight() error {
+	args, err := c.argv()
+	if err != nil {
+		return err
+	}
+
+	if _, err := exec.LookPath(programPath(c.cwd, args[0])); err != nil {
+		return &CommandNotFoundError{Name: args[0], Err: err}
+	}
+
+	if c.envFile != "" {
+		if _, err := readEnvFile(c.envFile); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// Terminate attempts to gracefully terminate the command process. If SIGTERM fails, it falls back
-// to force-killing the process group.  An error is returned if force-killing the process group
-// fails.
+// programPath returns the path at which exec.LookPath finds the given program of a command run in
+// the given working directory.  Programs given by a relative path containing a separator are
+// resolved against the working directory; others are returned unchanged.
+func programPath(cwd, name string) string {
+	if !strings.Contains(name, "/") || filepath.IsAbs(name) {
+		return name
+	}
+
+	path := filepath.Join(cwd, name)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	// The leading "./" keeps LookPath from searching PATH should the joined path lose all its
+	// separators.
+	return "./" + path
+}
+
+// argv returns the program and arguments of the command, read from the arguments file if one is
+// configured or split from the command string otherwise.
+func (c *commander) argv() ([]string, error) {
+	var args []string
+	if c.argsFile != "" {
+		var err error
+		if args, err = readArgsFile(c.argsFile); err != nil {
+			return nil, err
+		}
+	} else if c.shell != "" {
+		if strings.TrimSpace(c.command) == "" {
+			return nil, &EmptyCommandError{}
+		}
+		args = []string{c.shell, "-c", c.command}
+	} else {
+		var err error
+		if args, err = splitCommand(c.command); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(args) == 0 {
+		return nil, &EmptyCommandError{}
+	}
+	return args, nil
+}
+
+// prepareCleanDir replaces the copy of the source tree made for the previous run, if any, with a
+// fresh one, and returns the directory in which the command is to run.  Symbolic links are resolved
+// in both the root of the tree and the command's directory, since the root is found by FindGoModFile,
+// which resolves them, and the directory may lie beneath it through a symlink.
+func (c *commander) prepareCleanDir() (string, error) {
+	c.removeCleanDir()
+
+	root, err := resolvedPath(c.cleanRoot)
+	if err != nil {
+		return "", &CleanRunError{Root: c.cleanRoot, Err: err}
+	}
+	cwd, err := resolvedPath(c.cwd)
+	if err != nil {
+		return "", &CleanRunError{Root: c.cleanRoot, Err: err}
+	}
+	rel, err := filepath.Rel(root, cwd)
+	if err != nil {
+		return "", &CleanRunError{Root: c.cleanRoot, Err: err}
+	}
+
+	tmp, err := os.MkdirTemp("", "godepmon-run-")
+	if err != nil {
+		return "", &CleanRunError{Root: c.cleanRoot, Err: err}
+	}
+	c.cleanDir = tmp
+
+	start := time.Now()
+	if err := copyTree(root, tmp, cleanRunSkipDirs); err != nil {
+		return "", &CleanRunError{Root: c.cleanRoot, Err: err}
+	}
+	log.Debug().Msgf("copied %s to %s in %s", root, tmp, time.Since(start))
+
+	return filepath.Join(tmp, rel), nil
+}
+
+// resolvedPath returns the absolute path of the given path with all symbolic links resolved.
+func resolvedPath(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+// removeCleanDir removes the copy of the source tree made for the last run, if any.
+func (c *commander) removeCleanDir() {
+	if c.cleanDir == "" {
+		return
+	}
+
+	if err := os.RemoveAll(c.cleanDir); err != nil {
+		log.Warn().Msgf("unable to remove clean run directory: %s: %v", c.cleanDir, err)
+	}
+	c.cleanDir = ""
+}
+
+// Cleanup releases the resources held by the commander once it is no longer needed, such as the
+// copy of the source tree made for a clean run.  The command must have been terminated.
+func (c *commander) Cleanup() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeCleanDir()
+}
+
+// Dir returns the working directory of the command.
+func (c *commander) Dir() string {
+	return c.cwd
+}
+
+// Exited returns a channel that is closed once the most recently started command exits, or nil if
+// no command has been started.
+func (c *commander) Exited() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.exited
+}
+
+// ExitCode returns the exit code of the last command that exited of its own accord, as opposed to
+// being terminated by the commander.  A command killed by a signal is reported as 128 plus the
+// signal number, following shell convention.  It returns 0 if no command has exited yet.
+func (c *commander) ExitCode() int {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	return c.exitCode
+}
+
+// startFilter starts the filter command in its own process group.  It returns the filter along with
+// the write end of the pipe connected to the filter's standard input.
+func (c *commander) startFilter() (*exec.Cmd, *os.File, error) {
+	args, err := splitCommand(c.pipe)
+	if err != nil {
+		return nil, nil, err
+	} else if len(args) == 0 {
+		return nil, nil, &EmptyCommandError{}
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, &StartCommandError{Command: c.pipe, Err: err}
+	}
+
+	filter := exec.Command(args[0], args[1:]...)
+	filter.Dir = c.cwd
+	filter.Stdin = r
+	filter.Stdout = c.teeOutput(os.Stdout)
+	filter.Stderr = c.teeOutput(os.Stderr)
+	filter.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	log.Info().Msgf("running filter: %s", filter)
+	if err := filter.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return nil, nil, &StartCommandError{Command: c.pipe, Err: err}
+	}
+
+	return filter, w, nil
+}
+
+// waitFilter waits for the filter to drain its input and exit, killing its process group should it
+// not do so within the termination timeout.  If the command whose process group leader has the
+// given PID was terminated, its output is drained first.  A zero PID skips draining.
+func (c *commander) waitFilter(filter *exec.Cmd, pid int) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := filter.Wait(); err != nil {
+			log.Debug().Msgf("filter exited (PID %d): %v", filter.Process.Pid, err)
+		}
+	}()
+
+	if pid != 0 && c.isTerminating() {
+		drainOutput(pid, done)
+	}
+
+	select {
+	case <-done:
+		killGroup(filter.Process.Pid)
+	case <-time.After(c.terminationTimeout):
+		log.Warn().Msgf("filter did not exit, killing it (PID %d)", filter.Process.Pid)
+		syscall.Kill(-filter.Process.Pid, syscall.SIGKILL)
+		<-done
+	}
+}
+
+// Stats returns a summary of the outcome of the runs of the command so far.
+func (c *commander) Stats() RunStats {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	return c.stats
+}
+
+// Active reports whether the command is running or exited less than the given grace period ago.
+func (c *commander) Active(grace time.Duration) bool {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	return c.running || (!c.exitedAt.IsZero() && time.Since(c.exitedAt) < grace)
+}
+
+// IsOutput reports whether the given path matches one of the declared outputs of the command while
+// the command is running or exited less than the given grace period ago, in which case a change to
+// it is attributed to the command.
+func (c *commander) IsOutput(path string, grace time.Duration) bool {
+	if len(c.outputs) == 0 || !c.Active(grace) {
+		return false
+	}
+
+	cwd, err := filepath.Abs(c.cwd)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(cwd, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return false
+	}
+
+	// The path and each of its parent directories are matched, so that a glob matching a
+	// directory covers its whole subtree.
+	for p := rel; p != "."; p = filepath.Dir(p) {
+		for _, glob := range c.outputs {
+			if ok, _ := filepath.Match(glob, p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wait waits for the command to exit, and then for its output to be relayed from the terminal and
+// for the filter to exit, if any.  It records the command's exit status unless it was terminated by
+// the commander, removes the file listing the changed paths, if any, and closes the exited channel.
+func (c *commander) wait(cmd, filter *exec.Cmd, term *terminal, changes string, exited chan struct{}) {
+	defer close(exited)
+	defer removeChangedFile(changes)
+	defer c.printTail()
+	defer c.resetTerminal()
+	pid := cmd.Process.Pid
+	if filter != nil {
+		defer c.waitFilter(filter, pid)
+	}
+	if term != nil {
+		defer c.closeTerminal(term, pid)
+	}
+
+	err := cmd.Wait()
+	state := cmd.ProcessState
+	if state == nil {
+		log.Debug().Msgf("error waiting for program: %v", err)
+		return
+	}
+
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	c.running = false
+	c.exitedAt = time.Now()
+	c.stats.LastTerminated = c.terminating
+	if c.terminating {
+		c.stats.Terminated++
+		log.Debug().Msgf("program terminated (PID %d): %s", state.Pid(), state)
+		return
+	}
+
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && exceededCPULimit(ws) {
+		Error("Program exceeded its CPU time limit of %s", c.limits.CPU)
+	}
+
+	c.exitCode = exitCodeOf(state)
+	c.stats.LastExitCode = c.exitCode
+	if c.exitCode == 0 {
+		c.stats.Passed++
+	} else {
+		c.stats.Failed++
+	}
+	log.Info().Msgf("program exited (PID %d): %s", state.Pid(), state)
+}
+
+// teeOutput returns the writer to which output destined to w is written, which also records the
+// output in the tail buffer if one is configured.
+func (c *commander) teeOutput(w *os.File) io.Writer {
+	if c.tail == nil {
+		return w
+	}
+	return io.MultiWriter(w, c.tail)
+}
+
+// printTail prints the lines retained at the end of the command's output, if configured, provided
+// the command exited of its own accord with a non-zero status.
+func (c *commander) printTail() {
+	if c.tail == nil {
+		return
+	}
+
+	c.statusMu.Lock()
+	failed := !c.terminating && c.exitCode != 0
+	code := c.exitCode
+	c.statusMu.Unlock()
+
+	lines, partial := c.tail.Lines()
+	if !failed || len(lines) == 0 {
+		return
+	}
+
+	// The header must start on a line of its own, after output ending mid-line.
+	if partial {
+		fmt.Fprintln(os.Stderr)
+	}
+	Notice("--- Last %d lines of output (exit status %d) ---", len(lines), code)
+	for _, line := range lines {
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
+
+// closeTerminal closes the pseudo-terminal of the command whose process group leader has the given
+// PID, once its output is relayed.  If the command was terminated, its output is drained first.
+func (c *commander) closeTerminal(term *terminal, pid int) {
+	if c.isTerminating() {
+		drainOutput(pid, term.relayed)
+	}
+	term.close(c.terminationTimeout)
+}
+
+// resetTerminal resets the text attributes of the terminal, should the command have been terminated
+// while writing to it, in which case it may have been left with colored output turned on.
+func (c *commander) resetTerminal() {
+	if !c.isTerminating() {
+		return
+	}
+
+	if stat, err := os.Stdout.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		os.Stdout.WriteString(resetAttributes)
+	}
+}
+
+// isTerminating reports whether the command is being, or was, terminated by the commander.
+func (c *commander) isTerminating() bool {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	return c.terminating
+}
+
+// drainOutput gives the output of a terminated command, whose process group leader has the given
+// PID, until the done channel is closed to be consumed.  Should the output still be open once the
+// drain timeout elapses, the processes left behind by the command, which hold on to it, are killed
+// so that the output pending is flushed rather than cut off when the output is forcibly closed.
+func drainOutput(pid int, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(outputDrainTimeout):
+		log.Debug().Msgf("output still open, killing remaining processes (PID %d)", pid)
+		killGroup(pid)
+	}
+}
+
+// terminateOnCancel terminates the command when the context is cancelled, unless the stop channel
+// is closed first to signal that the command has already been terminated.
+func (c *commander) terminateOnCancel(ctx context.Context, stop chan struct{}) {
+	select {
+	case <-ctx.Done():
+		log.Debug().Msg("context cancelled, terminating program")
+		if err := c.Terminate(); err != nil {
+			log.Error().Msg(err.Error())
+		}
+	case <-stop:
+	}
+}
+
+// Terminate attempts to gracefully terminate the command process by walking the kill sequence, which
+// consists of SIGTERM by default.  If the process does not exit once the sequence is exhausted, it
+// falls back to force-killing the process group.  An error is returned if force-killing the process
+// group fails.
 func (c *commander) Terminate() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -112,37 +928,288 @@ func (c *commander) Terminate() error {
 		log.Debug().Msgf("not terminating program: not running")
 		return nil
 	}
+	defer c.reset()
 
-	log.Info().Msgf("terminating process group (PID %d)", c.cmd.Process.Pid)
-	if err := syscall.Kill(-c.cmd.Process.Pid, syscall.SIGTERM); err != nil {
-		log.Warn().Msgf("error sending SIGTERM to process group (PID %d): %v",
-			c.cmd.Process.Pid, err.Error())
-		return c.forceKill()
+	c.statusMu.Lock()
+	c.terminating = true
+	c.statusMu.Unlock()
+
+	pid := c.cmd.Process.Pid
+	select {
+	case <-c.exited:
+		log.Debug().Msgf("not terminating program: already exited (PID %d)", pid)
+		killGroup(pid)
+		return nil
+	default:
 	}
 
-	// FIXME: improve this so as to receive a signal when the process group terminates and not
-	//	  have to always sleep here.
-	time.Sleep(c.terminationTimeout)
+	steps, kill := c.killSequence, killStep{Signal: syscall.SIGKILL}
+	if n := len(steps); n > 0 && steps[n-1].Signal == syscall.SIGKILL {
+		steps, kill = steps[:n-1], steps[n-1]
+	}
 
-	if c.cmd.ProcessState != nil && c.cmd.ProcessState.Exited() {
-		return nil
+	log.Info().Msgf("terminating process group (PID %d)", pid)
+	for _, step := range steps {
+		log.Debug().Msgf("sending %s to process group (PID %d)", step.Signal, pid)
+		if err := syscall.Kill(-pid, step.Signal); err != nil && err != syscall.ESRCH {
+			log.Warn().Msgf("error sending %s to process group (PID %d): %v", step.Signal, pid, err)
+			continue
+		}
+
+		select {
+		case <-c.exited:
+			c.logTermination(step.Signal)
+			killGroup(pid)
+			return nil
+		case <-time.After(c.stepTimeout(step)):
+		}
 	}
 
-	return c.forceKill()
+	return c.forceKill(c.stepTimeout(kill))
+}
+
+// stepTimeout returns how long to wait for the command to exit after the given step of the kill
+// sequence.
+func (c *commander) stepTimeout(step killStep) time.Duration {
+	if step.Timeout > 0 {
+		return step.Timeout
+	}
+	return c.terminationTimeout
 }
 
-// forceKill forcefully terminates the process group associated with the commander's command. An
-// error is returned if the operation fails.
-func (c *commander) forceKill() error {
+// Signal sends the given signal to the process group of the running command.  A *NotRunningError
+// is returned if the command has not been started or has already exited.
+func (c *commander) Signal(sig syscall.Signal) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cmd == nil || c.cmd.Process == nil {
+		return &NotRunningError{}
+	}
+
+	select {
+	case <-c.exited:
+		return &NotRunningError{}
+	default:
+	}
+
+	pid := c.cmd.Process.Pid
+	log.Info().Msgf("sending %s to process group (PID %d)", sig, pid)
+	if err := syscall.Kill(-pid, sig); err != nil {
+		return &SignalCommandError{Pid: pid, Signal: sig, Err: err}
+	}
+
+	return nil
+}
+
+// reset discards the state associated with the terminated command so that subsequent calls to
+// Terminate are no-ops until the command is started again.
+func (c *commander) reset() {
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	c.cmd = nil
+}
+
+// forceKill forcefully terminates the process group associated with the commander's command and
+// waits up to the given timeout for it to exit. An error is returned if the operation fails.
+func (c *commander) forceKill(timeout time.Duration) error {
 	if c.cmd == nil || c.cmd.Process == nil {
 		log.Debug().Msgf("not forcefully killing program: not running")
 		return nil
 	}
 
 	log.Info().Msgf("forcefully killing process group (PID %d)", c.cmd.Process.Pid)
-	if err := syscall.Kill(-c.cmd.Process.Pid, syscall.SIGKILL); err != nil {
+	if err := syscall.Kill(-c.cmd.Process.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
 		return &ForceKillError{Pid: c.cmd.Process.Pid, Err: err}
 	}
 
+	select {
+	case <-c.exited:
+		c.logTermination(syscall.SIGKILL)
+	case <-time.After(timeout):
+		log.Warn().Msgf("program did not exit after being killed (PID %d)", c.cmd.Process.Pid)
+	}
+
 	return nil
 }
+
+// logTermination logs how the command ended once it exited following the given signal: with an
+// exit status, if it shut down of its own accord, or killed by a signal.  The command must have
+// exited.
+func (c *commander) logTermination(sent syscall.Signal) {
+	state := c.cmd.ProcessState
+	if state == nil {
+		log.Debug().Msgf("program exit status unknown after %s", signalName(sent))
+		return
+	}
+
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		log.Info().Msgf("program killed by %s after %s (PID %d)", signalName(ws.Signal()),
+			signalName(sent), state.Pid())
+		return
+	}
+	log.Info().Msgf("program exited with status %d after %s (PID %d)", state.ExitCode(),
+		signalName(sent), state.Pid())
+}
+
+// killGroup kills any processes left behind in the process group led by the given PID after the
+// leader has exited.  Failure is expected, and ignored, when no such processes remain.
+func killGroup(pid int) {
+	if err := syscall.Kill(-pid, syscall.SIGKILL); err == nil {
+		log.Debug().Msgf("killed processes left in process group (PID %d)", pid)
+	}
+}
+
+// exitCodeOf returns the exit code represented by the given process state.  Processes killed by a
+// signal are reported as 128 plus the signal number, following shell convention.
+func exitCodeOf(state *os.ProcessState) int {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+
+	return state.ExitCode()
+}
+
+// splitCommand splits a command string into the program and its arguments.  Arguments are separated
+// by whitespace, which is preserved within single or double quotes.  As in POSIX shells, a backslash
+// escapes the character that follows it outside of quotes, and only a double quote or backslash
+// within double quotes, while single quotes preserve everything literally.  An error is returned if
+// a quote is left unterminated.
+func splitCommand(command string) ([]string, error) {
+	args := []string{}
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else if r == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+				i++
+				arg.WriteRune(runes[i])
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == '\\' && i+1 < len(runes):
+			i++
+			arg.WriteRune(runes[i])
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, &UnterminatedQuoteError{Command: command}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// joinCommand joins the given arguments into a command string that splitCommand splits back into the
+// same arguments, quoting those that require it.  A single argument is taken to be a command string
+// already, so that a command given as one quoted shell word is split into its arguments.
+func joinCommand(args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n\r\v\f'\"\\") {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// readArgsFile reads a program and its arguments from the file at the given path, one per line.
+// Empty lines are skipped.
+func readArgsFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &ArgsFileError{Path: path, Err: err}
+	}
+
+	args := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSuffix(line, "\r"); line != "" {
+			args = append(args, line)
+		}
+	}
+	return args, nil
+}
+
+// writeChangedFile writes the given paths, one per line, to a new temporary file and returns its
+// path.
+func writeChangedFile(paths []string) (string, error) {
+	f, err := os.CreateTemp("", "godepmon-changes-")
+	if err != nil {
+		return "", &ChangedFileError{Err: err}
+	}
+
+	var content strings.Builder
+	for _, p := range paths {
+		content.WriteString(p)
+		content.WriteByte('\n')
+	}
+
+	_, err = f.WriteString(content.String())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		removeChangedFile(f.Name())
+		return "", &ChangedFileError{Err: err}
+	}
+
+	log.Debug().Msgf("listed %d changed paths in %s", len(paths), f.Name())
+	return f.Name(), nil
+}
+
+// removeChangedFile removes the file listing the changed paths at the given path, if any.
+func removeChangedFile(path string) {
+	if path == "" {
+		return
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warn().Msgf("unable to remove list of changed files: %s: %v", path, err)
+	}
+}
+
+// writeStdin writes content to the command's standard input and closes it.  Errors are logged
+// rather than returned, since the command may legitimately exit without reading all of its input.
+func writeStdin(stdin io.WriteCloser, content string) {
+	defer stdin.Close()
+
+	if _, err := io.WriteString(stdin, content); err != nil {
+		log.Debug().Msgf("error writing to program's standard input: %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"", []string{}},
+		{"   ", []string{}},
+		{"go run .", []string{"go", "run", "."}},
+		{" go\trun   . \n", []string{"go", "run", "."}},
+		{`go\ run .`, []string{"go run", "."}},
+		{`echo a\ b\"c`, []string{"echo", `a b"c`}},
+		{`echo 'hello world'`, []string{"echo", "hello world"}},
+		{`echo "hello world"`, []string{"echo", "hello world"}},
+		{`echo 'a "b" \c'`, []string{"echo", `a "b" \c`}},
+		{`echo "a 'b' \"c\" \\ \n"`, []string{"echo", `a 'b' "c" \ \n`}},
+		{`echo ''`, []string{"echo", ""}},
+		{`echo ""`, []string{"echo", ""}},
+		{`echo pre'mid dle'post`, []string{"echo", "premid dlepost"}},
+		{`echo \'quoted\'`, []string{"echo", "'quoted'"}},
+		{`echo trailing\`, []string{"echo", `trailing\`}},
+	}
+
+	for _, tt := range tests {
+		got, err := splitCommand(tt.command)
+		if err != nil {
+			t.Errorf("splitCommand(%q) returned error: %v", tt.command, err)
+		} else if !slices.Equal(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestSplitCommandUnterminatedQuote(t *testing.T) {
+	for _, command := range []string{`echo 'hello`, `echo "hello`, `echo "it's`, `echo 'say "hi"`} {
+		_, err := splitCommand(command)
+		var unterminated *UnterminatedQuoteError
+		if !errors.As(err, &unterminated) {
+			t.Errorf("splitCommand(%q) error = %v, want UnterminatedQuoteError", command, err)
+		}
+	}
+}
+
+func TestJoinCommand(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"go", "run", "."}, "go run ."},
+		{[]string{"echo", "hello world"}, "echo 'hello world'"},
+		{[]string{"echo", ""}, "echo ''"},
+		{[]string{"echo", "it's"}, `echo 'it'\''s'`},
+		{[]string{"echo", `a\b`}, `echo 'a\b'`},
+		// A single argument is a command string already.
+		{[]string{"go run ."}, "go run ."},
+	}
+
+	for _, tt := range tests {
+		if got := joinCommand(tt.args); got != tt.want {
+			t.Errorf("joinCommand(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestJoinCommandRoundTrip(t *testing.T) {
+	tests := [][]string{
+		{"go", "run", "."},
+		{"echo", "hello world", "tab\there"},
+		{"echo", "", "x"},
+		{"echo", "it's", `"quoted"`, `back\slash`},
+		{"sh", "-c", `printf '%s\n' "$1"`, "--", "a b"},
+		{"echo", "new\nline", " leading", "trailing "},
+	}
+
+	for _, args := range tests {
+		command := joinCommand(args)
+		got, err := splitCommand(command)
+		if err != nil {
+			t.Errorf("splitCommand(joinCommand(%q)) returned error: %v", args, err)
+		} else if !slices.Equal(got, args) {
+			t.Errorf("splitCommand(%q) = %q, want %q", command, got, args)
+		}
+	}
+}
+
+func TestProgramPath(t *testing.T) {
+	tests := []struct {
+		cwd, name, want string
+	}{
+		{"/tmp/proj", "./bin/app", "/tmp/proj/bin/app"},
+		{"/tmp/proj", "bin/app", "/tmp/proj/bin/app"},
+		{"/tmp/proj", "/usr/bin/env", "/usr/bin/env"},
+		{"/tmp/proj", "go", "go"},
+		{"proj", "./bin/app", "./proj/bin/app"},
+		{".", "./app", "./app"},
+		{"", "./app", "./app"},
+	}
+
+	for _, tt := range tests {
+		if got := programPath(tt.cwd, tt.name); got != tt.want {
+			t.Errorf("programPath(%q, %q) = %q, want %q", tt.cwd, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPreflightRelativeProgram(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bin", "app"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run from elsewhere, so that the program is only found relative to the command's directory.
+	chdir(t, t.TempDir())
+
+	if err := NewCommander(dir, "./bin/app").Preflight(); err != nil {
+		t.Errorf("absolute directory: %v", err)
+	}
+
+	rel, err := filepath.Rel(mustGetwd(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewCommander(rel, "./bin/app").Preflight(); err != nil {
+		t.Errorf("relative directory: %v", err)
+	}
+
+	var notFound *CommandNotFoundError
+	if err := NewCommander(dir, "./bin/missing").Preflight(); !errors.As(err, &notFound) {
+		t.Errorf("missing program: got %v, want CommandNotFoundError", err)
+	}
+}
+
+// chdir changes the working directory for the duration of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd := mustGetwd(t)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func mustGetwd(t *testing.T) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wd
+}
+
+func TestPrepareCleanDirSymlinkedDir(t *testing.T) {
+	root, link := symlinkedModule(t)
+	if err := os.WriteFile(filepath.Join(root, "sub", "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	gomod, err := NewGoMod(filepath.Join(link, "sub"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCommander(filepath.Join(link, "sub"), "go run .",
+		WithCleanRun(filepath.Dir(gomod.Path())))
+	defer c.Cleanup()
+
+	dir, err := c.prepareCleanDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(c.cleanDir, "sub"); dir != want {
+		t.Errorf("prepareCleanDir() = %q, want %q", dir, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "main.go")); err != nil {
+		t.Errorf("source file not copied: %v", err)
+	}
+}
+
+func TestIsOutput(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	coverage := filepath.Join(dir, "coverage.out")
+	bundle := filepath.Join(dir, "dist", "js", "app.js")
+	source := filepath.Join(dir, "main.go")
+
+	// The command writes its outputs and then waits to be told to exit by the removal of the
+	// file it polls, so that its outputs can be checked while it is running.
+	running := filepath.Join(dir, "running")
+	if err := os.WriteFile(running, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := NewCommander(dir, joinCommand([]string{"sh", "-c",
+		"echo 'mode: set' > coverage.out; " +
+			"mkdir -p dist/js && touch dist/js/app.js; while [ -e running ]; do sleep 0.01; done"}),
+		WithOutputs([]string{"coverage.out", "dist"}))
+	const grace = 200 * time.Millisecond
+
+	if c.IsOutput(coverage, grace) {
+		t.Error("output reported before the command started")
+	}
+
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(bundle); err == nil {
+			break
+		} else if time.Since(start) > 10*time.Second {
+			t.Fatal("command did not write its outputs")
+		}
+	}
+
+	for _, path := range []string{coverage, bundle} {
+		if !c.IsOutput(path, grace) {
+			t.Errorf("%s not reported as output while the command runs", path)
+		}
+	}
+	for _, path := range []string{source, filepath.Join(dir, "coverage.out.bak"),
+		filepath.Join(filepath.Dir(dir), "coverage.out")} {
+		if c.IsOutput(path, grace) {
+			t.Errorf("%s reported as output", path)
+		}
+	}
+
+	if err := os.Remove(running); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Exited():
+	case <-time.After(10 * time.Second):
+		t.Fatal("command did not exit")
+	}
+
+	if !c.IsOutput(coverage, grace) {
+		t.Error("output not reported during the grace period")
+	}
+	time.Sleep(grace)
+	if c.IsOutput(coverage, grace) {
+		t.Error("output reported after the grace period")
+	}
+}
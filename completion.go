@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+// registerCompletions configures the shell completion of arguments and flag values, which the
+// completion command provided by cobra relies on.  It must be called once all flags are defined.
+func registerCompletions() {
+	rootCmd.ValidArgsFunction = completePathThenCommand
+	graphCmd.ValidArgsFunction = completePath
+	watchOnlyCmd.ValidArgsFunction = completePath
+	benchCmd.ValidArgsFunction = completePath
+
+	completeValues := func(values ...string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+			return values, cobra.ShellCompDirectiveNoFileComp
+		}
+	}
+
+	cobra.CheckErr(rootCmd.RegisterFlagCompletionFunc("mod", completeValues(modModes...)))
+	cobra.CheckErr(rootCmd.MarkFlagFilename("config", "yaml", "yml"))
+	cobra.CheckErr(rootCmd.MarkFlagFilename("lock-file"))
+	cobra.CheckErr(rootCmd.MarkFlagFilename("command-args-file"))
+	cobra.CheckErr(rootCmd.MarkFlagFilename("manifest"))
+	cobra.CheckErr(rootCmd.MarkFlagFilename("command-shell-path"))
+	cobra.CheckErr(rootCmd.MarkFlagFilename("env-file"))
+	cobra.CheckErr(rootCmd.MarkFlagDirname("chdir"))
+	cobra.CheckErr(rootCmd.RegisterFlagCompletionFunc("task", completeTask))
+	cobra.CheckErr(rootCmd.RegisterFlagCompletionFunc("shutdown-signals", completeSignals))
+	cobra.CheckErr(rootCmd.RegisterFlagCompletionFunc("reload-signal", completeSignals))
+
+	// Flags taking a free-form value are not completed, rather than offering file names.
+	for _, name := range []string{
+		"pattern", "pkg", "watch-ext", "restart-jitter", "max-runtime", "warmup", "runs",
+		"start-retries", "event-buffer", "ignore-dir", "metrics-addr",
+		"kill-sequence", "output-glob", "watch-mod-cache", "max-concurrent",
+		"resolve-interval", "module-search-limit", "tail-on-fail", "debounce",
+		"timeout", "boot-window", "mem-limit", "cpu-limit",
+	} {
+		cobra.CheckErr(rootCmd.RegisterFlagCompletionFunc(name, completeValues()))
+	}
+}
+
+// completeTask completes the value of the --task flag with the names of the tasks defined in the
+// configuration file.
+func completeTask(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	cfg, err := LoadConfig(flags.configFile)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	return cfg.TaskNames(), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeSignals completes the value of the --shutdown-signals and --reload-signal flags with
+// signal names.
+func completeSignals(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	names := make([]string, 0, len(signalsByName))
+	for name := range signalsByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completePath completes the optional PATH argument with directory names.
+func completePath(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	return nil, cobra.ShellCompDirectiveFilterDirs
+}
+
+// completePathThenCommand completes the PATH argument with directory names and the arguments of
+// COMMAND that follow it with file names.
+func completePathThenCommand(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	}
+
+	return nil, cobra.ShellCompDirectiveDefault
+}
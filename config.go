@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultConfigFile specifies the name of the configuration file that is loaded from the
+	// current working directory, if present, when no configuration file is given explicitly.
+	defaultConfigFile = ".godepmon.yaml"
+
+	// defaultCommandEnv specifies the name of the environment variable that overrides the
+	// default command.
+	defaultCommandEnv = "GODEPMON_DEFAULT_CMD"
+)
+
+// ConfigError wraps an error encountered while loading a configuration file.
+type ConfigError struct {
+	Path string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("Failed to load configuration file '%s'\n%v", e.Path, e.Err)
+}
+
+// UnknownTaskError represents an error that occurs when a task is requested that is not defined in
+// the configuration file.
+type UnknownTaskError struct {
+	Name  string
+	Tasks []string
+}
+
+func (e *UnknownTaskError) Error() string {
+	if len(e.Tasks) == 0 {
+		return fmt.Sprintf("Unknown task '%s': no tasks are defined", e.Name)
+	}
+	return fmt.Sprintf("Unknown task '%s': must be one of %s", e.Name, strings.Join(e.Tasks, ", "))
+}
+
+// RuleError represents an error that occurs when a rule of the configuration file is invalid.
+type RuleError struct {
+	Index  int
+	Reason string
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("Invalid rule #%d: %s", e.Index+1, e.Reason)
+}
+
+// Rule maps a glob to a command that is run whenever a changed file matches the glob.
+type Rule struct {
+	// Glob is matched against the base name of changed files if it contains no path separator,
+	// and against their path relative to the watched path otherwise.
+	Glob string `yaml:"glob"`
+	// Command is the command run when a changed file matches Glob.
+	Command string `yaml:"command"`
+}
+
+// Matches reports whether the rule matches the given path, relative to the watched path.
+func (r Rule) Matches(rel string) bool {
+	name := rel
+	if !strings.Contains(r.Glob, "/") {
+		name = filepath.Base(rel)
+	}
+
+	ok, _ := filepath.Match(r.Glob, filepath.ToSlash(name))
+	return ok
+}
+
+// Config represents the contents of a godepmon configuration file.
+type Config struct {
+	// Command is the command to execute when none is given on the command line.
+	Command string `yaml:"command"`
+	// Startup is the command executed on the first run, taking precedence over Command.
+	Startup string `yaml:"startup"`
+	// Change is the command executed on every run following a change, taking precedence over
+	// Command.
+	Change string `yaml:"change"`
+	// Tasks maps task names to the commands selected by the --task flag.
+	Tasks map[string]string `yaml:"tasks"`
+	// Rules lists the commands run when changed files match a glob.
+	Rules []Rule `yaml:"rules"`
+}
+
+// LoadConfig reads and parses the configuration file at the specified path.  If path is empty, the
+// default configuration file is loaded from the current working directory if it exists; otherwise
+// an empty configuration is returned.
+func LoadConfig(path string) (*Config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigFile
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return &Config{}, nil
+		}
+		return nil, &ConfigError{Path: path, Err: err}
+	}
+
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, &ConfigError{Path: path, Err: err}
+	}
+
+	for i, r := range cfg.Rules {
+		if r.Glob == "" || r.Command == "" {
+			return nil, &ConfigError{Path: path,
+				Err: &RuleError{Index: i, Reason: "glob and command are required"}}
+		} else if _, err := filepath.Match(r.Glob, ""); err != nil {
+			return nil, &ConfigError{Path: path,
+				Err: &RuleError{Index: i, Reason: fmt.Sprintf("malformed glob '%s'", r.Glob)}}
+		}
+	}
+
+	return cfg, nil
+}
+
+// DefaultCommand returns the command to execute when none is given on the command line.  The
+// GODEPMON_DEFAULT_CMD environment variable takes precedence over the configuration file, and the
+// built-in default is used if neither is set.
+func (cfg *Config) DefaultCommand() string {
+	if command := os.Getenv(defaultCommandEnv); command != "" {
+		return command
+	}
+
+	if cfg.Command != "" {
+		return cfg.Command
+	}
+
+	return defaultCommand
+}
+
+// DefaultCommands returns the commands to execute on the first run and on every run following a
+// change, respectively, when none is given on the command line.  The startup and change keys of the
+// configuration file each take precedence over its command key, which otherwise applies to both as
+// returned by DefaultCommand.  The GODEPMON_DEFAULT_CMD environment variable takes precedence over
+// all of them.
+func (cfg *Config) DefaultCommands() (startup string, change string) {
+	startup, change = cfg.DefaultCommand(), cfg.DefaultCommand()
+	if os.Getenv(defaultCommandEnv) != "" {
+		return startup, change
+	}
+
+	if cfg.Startup != "" {
+		startup = cfg.Startup
+	}
+	if cfg.Change != "" {
+		change = cfg.Change
+	}
+	return startup, change
+}
+
+// Task returns the command of the named task.  An error is returned if no such task is defined.
+func (cfg *Config) Task(name string) (string, error) {
+	if command, ok := cfg.Tasks[name]; ok {
+		return command, nil
+	}
+
+	return "", &UnknownTaskError{Name: name, Tasks: cfg.TaskNames()}
+}
+
+// TaskNames returns the sorted names of the defined tasks.
+func (cfg *Config) TaskNames() []string {
+	names := make([]string, 0, len(cfg.Tasks))
+	for name := range cfg.Tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -11,6 +11,12 @@ func Error(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, format+"\n", args...)
 }
 
+// Notice writes an informational message formatted according to a format specifier and arguments
+// to the standard error stream, regardless of the logging level.  It appends a newline to the output.
+func Notice(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
 // Fatal is similar to Error but additionally exits the program with a status code of 1, indicating
 // an abnormal termination.
 func Fatal(format string, args ...interface{}) {
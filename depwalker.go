@@ -2,12 +2,67 @@ package main
 
 import (
 	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 
+	"github.com/rs/zerolog/log"
 	"golang.org/x/tools/go/packages"
 )
 
+const (
+	// defaultPattern specifies the package pattern loaded by default, relative to the walked
+	// directory.
+	defaultPattern = "./..."
+
+	// adHocPackagePath is the path given by the go command to the package formed by Go files named
+	// on the command line, as done in single-file mode.
+	adHocPackagePath = "command-line-arguments"
+)
+
+// NoPackagesError represents an error that occurs when a package pattern matches no packages.
+type NoPackagesError struct {
+	Pattern string
+	Path    string
+}
+
+func (e *NoPackagesError) Error() string {
+	return fmt.Sprintf("Pattern '%s' matched no packages in %s", e.Pattern, e.Path)
+}
+
+// modModes lists the values accepted by the -mod build flag.
+var modModes = []string{"mod", "readonly", "vendor"}
+
+// ModModeError represents an error that occurs when an unsupported module download mode is given.
+type ModModeError struct {
+	Mode string
+}
+
+func (e *ModModeError) Error() string {
+	return fmt.Sprintf("Invalid module mode '%s': must be one of %s",
+		e.Mode, strings.Join(modModes, ", "))
+}
+
+// ValidateModMode returns an error if mode is neither empty nor a value accepted by the -mod build
+// flag.
+func ValidateModMode(mode string) error {
+	if mode == "" {
+		return nil
+	}
+
+	for _, m := range modModes {
+		if mode == m {
+			return nil
+		}
+	}
+	return &ModModeError{Mode: mode}
+}
+
 // Deps represents a slice of dependency file paths.
 type Deps []string
 
@@ -17,22 +72,412 @@ type depWalker struct {
 	module              string
 	moduleWithSlash     string
 	includeExternalDeps bool
+	includeTests        bool
+	extensions          []string
+	patterns            []string
+	reportPackages      bool
+	matched             int
+	watchTestdata       bool
+	ignoreDirs          []string
+	modMode             string
+	maxDepth            int
+	nestedModules       bool
+	singleFile          bool
+	fast                bool
+	watchedImports      []string
+	allPackages         bool
+	moduleSearchLimit   int
+	excludeGenerated    bool
 }
 
+// depWalkerOption defines a function signature for options that configure a depWalker instance.
+type depWalkerOption func(dw *depWalker)
+
 // NewDepWalker creates a new dependency walker with the specified options.  It returns a *depWalker
 // configured according to the provided parameters.
-func NewDepWalker(includeExternalDeps bool) *depWalker {
-	return &depWalker{
+func NewDepWalker(includeExternalDeps bool, options ...depWalkerOption) *depWalker {
+	dw := &depWalker{
 		includeExternalDeps: includeExternalDeps,
+		patterns:            []string{defaultPattern},
+	}
+
+	for _, setopt := range options {
+		setopt(dw)
+	}
+
+	return dw
+}
+
+// WithTests configures whether test packages, and hence the packages imported only by tests, are
+// included in the dependency graph.
+func WithTests(include bool) depWalkerOption {
+	return func(dw *depWalker) {
+		dw.includeTests = include
+	}
+}
+
+// WithExtensions configures additional file extensions, such as "proto", whose files are included
+// in the dependency list when they reside in the directory of an included package.
+func WithExtensions(extensions []string) depWalkerOption {
+	return func(dw *depWalker) {
+		dw.extensions = make([]string, 0, len(extensions))
+		for _, ext := range extensions {
+			dw.extensions = append(dw.extensions, "."+strings.TrimPrefix(ext, "."))
+		}
+	}
+}
+
+// WithPattern configures the package pattern, such as "." or "./api/...", whose packages and their
+// dependencies are walked.  The pattern is interpreted relative to the walked directory.
+func WithPattern(pattern string) depWalkerOption {
+	return func(dw *depWalker) {
+		dw.patterns = []string{pattern}
+	}
+}
+
+// WithPatterns configures several package patterns, resolved as by "go list", whose packages are
+// loaded together and unioned, along with their dependencies.  The number of packages matched is
+// logged every time the dependencies are listed.  No patterns keeps the configured pattern.
+func WithPatterns(patterns []string) depWalkerOption {
+	return func(dw *depWalker) {
+		if len(patterns) > 0 {
+			dw.patterns = patterns
+			dw.reportPackages = true
+		}
+	}
+}
+
+// WithTestdata configures whether the testdata directories of included packages, which the Go
+// tooling otherwise ignores, are included in the dependency list along with all their
+// subdirectories.
+func WithTestdata(include bool) depWalkerOption {
+	return func(dw *depWalker) {
+		dw.watchTestdata = include
 	}
 }
 
+// WithIgnoreDirs configures the names of the directories skipped when walking directory trees.
+func WithIgnoreDirs(names []string) depWalkerOption {
+	return func(dw *depWalker) {
+		dw.ignoreDirs = names
+	}
+}
+
+// WithModMode configures the -mod build flag used when loading packages, which forces how module
+// dependencies are resolved. An empty mode leaves the decision to the go command.
+func WithModMode(mode string) depWalkerOption {
+	return func(dw *depWalker) {
+		dw.modMode = mode
+	}
+}
+
+// WithMaxDepth configures the maximum number of import levels followed from the packages matched by
+// the pattern.  A depth of one only includes their direct imports, while zero, the default, follows
+// imports transitively without limit.
+func WithMaxDepth(depth int) depWalkerOption {
+	return func(dw *depWalker) {
+		dw.maxDepth = depth
+	}
+}
+
+// WithNestedModules configures whether the packages of the modules nested beneath the walked
+// directory, which package patterns do not descend into, are also listed.
+func WithNestedModules(nested bool) depWalkerOption {
+	return func(dw *depWalker) {
+		dw.nestedModules = nested
+	}
+}
+
+// WithSingleFile configures whether the pattern names a single Go file rather than packages.  The
+// file may reside outside of any module, in which case it is included, along with its external
+// dependencies if enabled, as the sole package of interest.
+func WithSingleFile(singleFile bool) depWalkerOption {
+	return func(dw *depWalker) {
+		dw.singleFile = singleFile
+	}
+}
+
+// WithFast configures whether packages are loaded without their dependencies, which spares the go
+// command from resolving the files of every standard library and external package.  The imports of
+// the loaded packages are instead resolved against all packages of the module, loaded concurrently.
+// It has no effect when external dependencies are included, external packages are watched by import
+// path or in single-file mode.
+func WithFast(fast bool) depWalkerOption {
+	return func(dw *depWalker) {
+		dw.fast = fast
+	}
+}
+
+// WithWatchedImports configures the import paths of external packages that are included even when
+// external dependencies are not, such as a dependency being edited in the module cache.  A path
+// also matches the packages beneath it.  Matching packages are included wherever they appear in the
+// dependency graph, regardless of the maximum import depth.
+func WithWatchedImports(paths []string) depWalkerOption {
+	return func(dw *depWalker) {
+		dw.watchedImports = paths
+	}
+}
+
+// WithAllPackages configures whether the files of every package of the module are included, whether
+// or not they are reachable from the packages matched by the pattern, so that code not yet imported
+// is included too.
+func WithAllPackages(all bool) depWalkerOption {
+	return func(dw *depWalker) {
+		dw.allPackages = all
+	}
+}
+
+// WithModuleSearchLimit configures the maximum number of directories searched for the go.mod file of
+// the walked directory, starting with the directory itself and moving up.  Zero imposes no limit.
+func WithModuleSearchLimit(limit int) depWalkerOption {
+	return func(dw *depWalker) {
+		dw.moduleSearchLimit = limit
+	}
+}
+
+// WithExcludeGenerated configures whether Go files marked as generated, by a "// Code generated ...
+// DO NOT EDIT." comment preceding their package clause, are left out of the dependency list.
+func WithExcludeGenerated(exclude bool) depWalkerOption {
+	return func(dw *depWalker) {
+		dw.excludeGenerated = exclude
+	}
+}
+
+// Graph represents the import graph of the packages included by a depWalker, mapping the ID of each
+// package to the sorted IDs of the included packages it imports.
+type Graph map[string][]string
+
 // List generates a list of dependency file paths for a given directory path. It returns an error if
 // the dependencies cannot be determined. If includeExternalDeps is false, only dependencies within
 // the same module are included.
 func (dw *depWalker) List(path string) (Deps, error) {
+	deps, err := dw.list(path, dw.patterns)
+	if err != nil {
+		return nil, err
+	}
+	if dw.reportPackages {
+		log.Info().Msgf("resolved %d packages matching %s", dw.matched,
+			strings.Join(dw.patterns, " "))
+	}
+
+	if dw.allPackages {
+		all, err := dw.listModule(path)
+		if err != nil {
+			return nil, err
+		}
+		deps = dedupeSorted(append(deps, all...))
+	}
+
+	if dw.nestedModules {
+		nested, err := dw.listNestedModules(path)
+		if err != nil {
+			return nil, err
+		}
+		deps = dedupeSorted(append(deps, nested...))
+	}
+
+	return deps, nil
+}
+
+// list generates the sorted list of dependency file paths of the packages matching the given
+// patterns in the given directory.
+func (dw *depWalker) list(path string, patterns []string) (Deps, error) {
+	imports, err := dw.walk(path, patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	// A package and its test variant share most of their files.
+	seen := make(map[string]struct{})
+	dirs := make(map[string]struct{})
+	deps := []string{}
+	for _, pkg := range imports {
+		for _, f := range pkg.GoFiles {
+			dirs[filepath.Dir(f)] = struct{}{}
+			if _, ok := seen[f]; ok {
+				continue
+			}
+			seen[f] = struct{}{}
+			if dw.excludeGenerated && isGenerated(f) {
+				log.Trace().Msgf("not watching generated file: %s", f)
+				continue
+			}
+			deps = append(deps, f)
+		}
+	}
+
+	if len(dw.extensions) > 0 {
+		for dir := range dirs {
+			deps = append(deps, dw.listExtensionFiles(dir)...)
+		}
+	}
+
+	if dw.watchTestdata {
+		for dir := range dirs {
+			deps = append(deps, dw.listTestdataDirs(dir)...)
+		}
+	}
+
+	sort.Strings(deps)
+	return deps, nil
+}
+
+// isGenerated reports whether the Go file at the given path is marked as generated.  Only the
+// comments preceding the package clause are parsed.  Files that cannot be parsed are not
+// considered generated.
+func isGenerated(path string) bool {
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil,
+		parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		log.Trace().Msgf("unable to parse file: %s: %v", path, err)
+		return false
+	}
+
+	return ast.IsGenerated(file)
+}
+
+// listModule generates the list of dependency file paths of all the packages of the module
+// containing the given directory.  In single-file mode, a file outside of any module has no module
+// packages to list.
+func (dw *depWalker) listModule(path string) (Deps, error) {
+	gomod, err := NewGoMod(path, dw.moduleSearchLimit)
+	if err != nil && dw.singleFile {
+		log.Debug().Msgf("not listing module packages: %v", err)
+		return Deps{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return dw.list(filepath.Dir(gomod.Path()), []string{defaultPattern})
+}
+
+// listNestedModules generates the list of dependency file paths of all the packages of the modules
+// nested beneath the given directory, as identified by their go.mod files.  Nested modules whose
+// packages cannot be loaded are logged and skipped.
+func (dw *depWalker) listNestedModules(path string) (Deps, error) {
+	modules := []string{}
+	err := walkDirs(path, dw.ignoreDirs, func(dir string) error {
+		if dir == path {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			modules = append(modules, dir)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deps := Deps{}
+	for _, dir := range modules {
+		log.Debug().Msgf("listing dependencies of nested module: %s", dir)
+		mdeps, err := dw.list(dir, []string{defaultPattern})
+		if err != nil {
+			log.Warn().Msgf("not watching nested module: %s: %v", dir, err)
+			continue
+		}
+		deps = append(deps, mdeps...)
+	}
+
+	return deps, nil
+}
+
+// dedupeSorted sorts deps and removes duplicate paths.
+func dedupeSorted(deps Deps) Deps {
+	sort.Strings(deps)
+
+	out := deps[:0]
+	for i, p := range deps {
+		if i == 0 || p != deps[i-1] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// listExtensionFiles returns the paths of the files in dir whose extension is one of the configured
+// additional extensions.  Errors reading the directory are logged and otherwise ignored.
+func (dw *depWalker) listExtensionFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Debug().Msgf("unable to list package directory: %s: %v", dir, err)
+		return nil
+	}
+
+	files := []string{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		for _, ext := range dw.extensions {
+			if filepath.Ext(e.Name()) == ext {
+				files = append(files, filepath.Join(dir, e.Name()))
+				break
+			}
+		}
+	}
+
+	return files
+}
+
+// listTestdataDirs returns the testdata directory of the package residing in dir, if there is one,
+// along with all of its subdirectories.  Watching the directories, rather than the files they
+// contain, ensures that fixtures added later are noticed too.
+func (dw *depWalker) listTestdataDirs(dir string) []string {
+	root := filepath.Join(dir, "testdata")
+	if stat, err := os.Stat(root); err != nil || !stat.IsDir() {
+		return nil
+	}
+
+	dirs := []string{}
+	err := walkDirs(root, dw.ignoreDirs, func(d string) error {
+		dirs = append(dirs, d)
+		return nil
+	})
+	if err != nil {
+		log.Debug().Msgf("unable to walk testdata directory: %s: %v", root, err)
+	}
+
+	return dirs
+}
+
+// Graph generates the import graph of the dependencies of a given directory path, subject to the
+// same inclusion criteria as List.  It returns an error if the dependencies cannot be determined.
+func (dw *depWalker) Graph(path string) (Graph, error) {
+	imports, err := dw.walk(path, dw.patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := make(Graph, len(imports))
+	for id, pkg := range imports {
+		edges := []string{}
+		for _, i := range pkg.Imports {
+			if _, ok := imports[i.ID]; ok {
+				edges = append(edges, i.ID)
+			}
+		}
+
+		sort.Strings(edges)
+		graph[id] = edges
+	}
+
+	return graph, nil
+}
+
+// walk loads the packages matching the given patterns under the given directory path and returns
+// all the packages reachable from them that meet the inclusion criteria, keyed by ID.  The number of
+// packages matched is recorded.
+func (dw *depWalker) walk(path string, patterns []string) (map[string]*packages.Package, error) {
+	pattern := strings.Join(patterns, " ")
 	if !dw.includeExternalDeps {
-		if gomod, err := NewGoMod(path); err != nil {
+		if gomod, err := NewGoMod(path, dw.moduleSearchLimit); err != nil && dw.singleFile {
+			log.Debug().Msgf("loading %s outside of any module: %v", pattern, err)
+			dw.module = adHocPackagePath
+			dw.moduleWithSlash = adHocPackagePath + "/"
+		} else if err != nil {
 			return nil, err
 		} else if module, err := gomod.Module(); err != nil {
 			return nil, err
@@ -43,56 +488,193 @@ func (dw *depWalker) List(path string) (Deps, error) {
 	}
 
 	cfg := &packages.Config{
-		Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps,
-		Dir:  path,
+		Mode:  packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps,
+		Dir:   path,
+		Tests: dw.includeTests,
+	}
+	if dw.modMode != "" {
+		cfg.BuildFlags = []string{"-mod=" + dw.modMode}
 	}
 
-	pkgs, err := packages.Load(cfg, "./...")
+	// In fast mode, the packages of the module are loaded concurrently so that the second load
+	// adds little to the time taken.
+	var modulePkgs []*packages.Package
+	var moduleErr error
+	var wg sync.WaitGroup
+	fast := dw.fast && !dw.includeExternalDeps && !dw.singleFile && len(dw.watchedImports) == 0
+	if fast {
+		cfg.Mode &^= packages.NeedDeps
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			modulePkgs, moduleErr = packages.Load(cfg, dw.module+"/...")
+		}()
+	}
+
+	pkgs, err := packages.Load(cfg, patterns...)
+	wg.Wait()
 	if err != nil {
 		return nil, fmt.Errorf("failed to load packages: %s", err)
 	}
 
+	if !hasPackages(pkgs) {
+		return nil, &NoPackagesError{Pattern: pattern, Path: path}
+	}
+
+	if fast {
+		if moduleErr != nil {
+			return nil, fmt.Errorf("failed to load module packages: %s", moduleErr)
+		}
+		linkImports(modulePkgs, pkgs)
+	}
+
+	dw.matched = countPackages(pkgs)
 	imports := make(map[string]*packages.Package)
 	dw.visitAll(pkgs, imports)
+	dw.visitWatched(pkgs, imports)
+	return imports, nil
+}
 
-	deps := []string{}
-	for _, pkg := range imports {
-		for _, f := range pkg.GoFiles {
-			deps = append(deps, f)
+// linkImports replaces the imports of the given packages, loaded without their dependencies, with
+// the packages of the module loaded separately, so that they can be visited as when loaded along
+// with their dependencies.  Imports of packages outside of the module are dropped, as they are not
+// included anyway.
+func linkImports(modulePkgs []*packages.Package, pkgs []*packages.Package) {
+	byID := make(map[string]*packages.Package, len(modulePkgs)+len(pkgs))
+	for _, pkg := range modulePkgs {
+		byID[pkg.ID] = pkg
+	}
+	for _, pkg := range pkgs {
+		byID[pkg.ID] = pkg
+	}
+
+	for _, pkg := range byID {
+		for path, i := range pkg.Imports {
+			if loaded, ok := byID[i.ID]; ok {
+				pkg.Imports[path] = loaded
+			} else {
+				delete(pkg.Imports, path)
+			}
 		}
 	}
+}
 
-	sort.Strings(deps)
-	return deps, nil
+// hasPackages reports whether pkgs contains at least one actual package, as opposed to only the
+// placeholders reported when a pattern does not resolve to any package.
+func hasPackages(pkgs []*packages.Package) bool {
+	for _, pkg := range pkgs {
+		if len(pkg.GoFiles) > 0 || len(pkg.Errors) == 0 {
+			return true
+		}
+	}
+	return false
 }
 
-// visitAll recursively visits all packages reachable from the initial set, adding them to the
-// imports map if they meet the inclusion criteria defined by isCandidate.
-func (dw *depWalker) visitAll(pkgs []*packages.Package, imports map[string]*packages.Package) {
+// countPackages returns the number of distinct packages among those loaded, counting a package and
+// its test variants once, and leaving out the placeholders of patterns matching no package.
+func countPackages(pkgs []*packages.Package) int {
+	paths := make(map[string]struct{}, len(pkgs))
 	for _, pkg := range pkgs {
-		if _, ok := imports[pkg.PkgPath]; ok {
-			continue
+		if len(pkg.GoFiles) > 0 {
+			paths[pkg.PkgPath] = struct{}{}
 		}
+	}
+	return len(paths)
+}
 
-		if !dw.isCandidate(pkg.PkgPath) {
-			continue
+// visitAll visits all packages reachable from the initial set, level by level, adding them to the
+// imports map if they meet the inclusion criteria defined by classify.  Packages are keyed by ID
+// rather than import path since, when tests are included, a package and its test variant share the
+// same import path but not the same files.  Packages further than the maximum import depth from the
+// initial set, if one is configured, are not visited.
+func (dw *depWalker) visitAll(pkgs []*packages.Package, imports map[string]*packages.Package) {
+	level := pkgs
+	for depth := 0; len(level) > 0; depth++ {
+		if dw.maxDepth > 0 && depth > dw.maxDepth {
+			return
 		}
 
-		imports[pkg.PkgPath] = pkg
+		var next []*packages.Package
+		for _, pkg := range level {
+			if _, ok := imports[pkg.ID]; ok {
+				log.Trace().Msgf("skipping package %s at depth %d: already visited", pkg.ID,
+					depth)
+				continue
+			}
 
-		pi := make([]*packages.Package, 0, len(pkg.Imports))
-		for _, i := range pkg.Imports {
-			pi = append(pi, i)
+			// Test executables consist solely of a generated main file residing in the
+			// build cache, which is of no interest to the watcher.
+			if dw.includeTests && strings.HasSuffix(pkg.ID, ".test") {
+				log.Trace().Msgf("excluding package %s at depth %d: test executable", pkg.ID,
+					depth)
+				continue
+			}
+
+			ok, reason := dw.classify(pkg.PkgPath)
+			if !ok {
+				log.Trace().Msgf("excluding package %s at depth %d: %s", pkg.ID, depth, reason)
+				continue
+			}
+
+			log.Trace().Msgf("including package %s at depth %d: %s", pkg.ID, depth, reason)
+			imports[pkg.ID] = pkg
+			for _, i := range pkg.Imports {
+				next = append(next, i)
+			}
 		}
 
-		dw.visitAll(pi, imports)
+		level = next
 	}
 }
 
-// isCandidate determines whether a package path should be considered for inclusion based on the
-// DepWalker's configuration.
-func (dw *depWalker) isCandidate(pkgPath string) bool {
-	return dw.includeExternalDeps ||
-		pkgPath == dw.module ||
-		strings.HasPrefix(pkgPath, dw.moduleWithSlash)
+// visitWatched adds the packages reachable from the initial set that match the watched import
+// paths to the imports map.  Unlike visitAll, it follows the imports of every package, since a
+// watched package may only be imported by other external packages.
+func (dw *depWalker) visitWatched(pkgs []*packages.Package, imports map[string]*packages.Package) {
+	if dw.includeExternalDeps || len(dw.watchedImports) == 0 {
+		return
+	}
+
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		if _, ok := imports[pkg.ID]; !ok && dw.isWatchedImport(pkg.PkgPath) {
+			imports[pkg.ID] = pkg
+		}
+	})
+}
+
+// isWatchedImport reports whether a package path matches one of the watched import paths.
+func (dw *depWalker) isWatchedImport(pkgPath string) bool {
+	for _, path := range dw.watchedImports {
+		if pkgPath == path || strings.HasPrefix(pkgPath, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// classify determines whether a package path should be considered for inclusion based on the
+// DepWalker's configuration, along with the reason for the decision, which is logged when tracing
+// the traversal.
+func (dw *depWalker) classify(pkgPath string) (bool, string) {
+	if dw.includeTests {
+		// External test packages are named after the package under test.
+		pkgPath = strings.TrimSuffix(pkgPath, "_test")
+	}
+
+	// The file given in single-file mode forms a package of its own, even within a module.
+	if dw.singleFile && pkgPath == adHocPackagePath {
+		return true, "single file"
+	}
+
+	// The module is not determined when external dependencies are included.
+	switch {
+	case dw.module != "" && pkgPath == dw.module:
+		return true, "module root package"
+	case dw.module != "" && strings.HasPrefix(pkgPath, dw.moduleWithSlash):
+		return true, "matches module prefix " + dw.moduleWithSlash
+	case dw.includeExternalDeps:
+		return true, "external dependencies included"
+	default:
+		return false, "external to module " + dw.module
+	}
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvFileError represents an error that occurs when the environment file of the command cannot be
+// read or parsed.  Line is zero if the file cannot be read.
+type EnvFileError struct {
+	Path string
+	Line int
+	Err  error
+}
+
+func (e *EnvFileError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("Failed to read environment file '%s'\n%v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("Invalid environment file '%s' at line %d\n%v", e.Path, e.Line, e.Err)
+}
+
+// readEnvFile reads the variables defined in the dotenv file at the given path, returning them in
+// the "KEY=VALUE" form of os.Environ, in the order in which they are defined.  Every line holds a
+// KEY=VALUE assignment, optionally preceded by "export".  Empty lines and lines starting with '#'
+// are skipped.  Values may be enclosed in single quotes, which preserve everything literally, or
+// in double quotes, within which \n, \t, \", \\ and \$ are escapes.  Unquoted values are trimmed and
+// end at a '#' preceded by whitespace, which starts a comment.
+func readEnvFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &EnvFileError{Path: path, Err: err}
+	}
+
+	env := []string{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(strings.TrimSuffix(line, "\r"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok {
+			return nil, &EnvFileError{Path: path, Line: i + 1, Err: errors.New("missing '='")}
+		} else if !isEnvName(key) {
+			return nil, &EnvFileError{Path: path, Line: i + 1,
+				Err: fmt.Errorf("invalid variable name '%s'", key)}
+		}
+
+		value, err := parseEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, &EnvFileError{Path: path, Line: i + 1, Err: err}
+		}
+		env = append(env, key+"="+value)
+	}
+
+	return env, nil
+}
+
+// parseEnvValue parses the value of an assignment in a dotenv file, as described by readEnvFile.
+func parseEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	switch quote := value[0]; quote {
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated single quote")
+		}
+		return value[1 : end+1], nil
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(value); i++ {
+			c := value[i]
+			if c == '"' {
+				return b.String(), nil
+			} else if c != '\\' || i+1 == len(value) {
+				b.WriteByte(c)
+				continue
+			}
+
+			i++
+			switch value[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\', '$':
+				b.WriteByte(value[i])
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(value[i])
+			}
+		}
+		return "", errors.New("unterminated double quote")
+	}
+
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	} else if i := strings.Index(value, "\t#"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value), nil
+}
+
+// isEnvName reports whether the given string is a valid environment variable name: a letter or
+// underscore followed by letters, digits or underscores.
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
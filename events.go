@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// eventRestartBegin is emitted when a change has been detected and the command is about to be
+	// terminated so that it can be restarted.
+	eventRestartBegin = "restart-begin"
+	// eventRestartEnd is emitted once the command has been restarted.
+	eventRestartEnd = "restart-end"
+	// eventReload is emitted when a change has been detected and the reload signal has been sent to
+	// the running command in lieu of restarting it.
+	eventReload = "reload"
+	// eventSeparator is emitted before every start of the command when separators are enabled,
+	// in lieu of the divider line printed otherwise.
+	eventSeparator = "separator"
+)
+
+// eventEmitter reports lifecycle events, such as the command being restarted, so that they can be
+// consumed by external tools.  Events are always logged and, if a writer is configured, also
+// written to it as JSON objects, one per line.
+type eventEmitter struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// NewEventEmitter creates a new event emitter that writes JSON events to w.  If w is nil, events are
+// only logged.
+func NewEventEmitter(w io.Writer) *eventEmitter {
+	return &eventEmitter{w: w}
+}
+
+// Structured reports whether events are written as JSON objects, rather than only logged.
+func (e *eventEmitter) Structured() bool {
+	return e != nil && e.w != nil
+}
+
+// Emit reports the named event along with the given fields, which may be nil.
+func (e *eventEmitter) Emit(name string, fields map[string]interface{}) {
+	log.Info().Fields(fields).Msgf("event: %s", name)
+	if e == nil || e.w == nil {
+		return
+	}
+
+	event := make(map[string]interface{}, len(fields)+2)
+	for k, v := range fields {
+		event[k] = v
+	}
+	event["event"] = name
+	event["time"] = time.Now().Format(time.RFC3339Nano)
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Error().Msgf("unable to encode event %s: %v", name, err)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, err := e.w.Write(append(data, '\n')); err != nil {
+		log.Debug().Msgf("unable to write event %s: %v", name, err)
+	}
+}
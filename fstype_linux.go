@@ -0,0 +1,30 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// unreliableFilesystems maps the magic numbers of the file systems known not to deliver file system
+// events for changes made outside of the machine godepmon runs on, as with the sources of a
+// container bind-mounted from the host, to their names.
+var unreliableFilesystems = map[uint32]string{
+	0x01021997: "9p",
+	0x65735546: "fuse",
+	0x6a656a63: "virtiofs",
+	0x6969:     "nfs",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x786f4256: "vboxsf",
+}
+
+// unreliableFilesystem reports whether the given path resides on a file system that may not
+// deliver file system events, along with the name of the file system.
+func unreliableFilesystem(path string) (string, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return "", false
+	}
+
+	name, ok := unreliableFilesystems[uint32(st.Type)]
+	return name, ok
+}
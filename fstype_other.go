@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// unreliableFilesystem reports that no file system is known to be unreliable, since file system
+// types are only detected on Linux.
+func unreliableFilesystem(path string) (string, bool) {
+	return "", false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultIgnoreDirs lists the names of the directories that are skipped by default when walking
+// directory trees.
+var defaultIgnoreDirs = []string{".git", "node_modules", "vendor"}
+
+// walkDirs calls fn for root and for every directory beneath it, in lexical order.  Directories
+// whose base name is listed in ignore are skipped along with their entire subtree, as are
+// directories that cannot be read.  The root itself is never ignored.
+func walkDirs(root string, ignore []string, fn func(dir string) error) error {
+	skip := make(map[string]struct{}, len(ignore))
+	for _, name := range ignore {
+		skip[name] = struct{}{}
+	}
+
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Debug().Msgf("not walking unreadable path: %s: %v", path, err)
+			if d != nil && d.IsDir() && path != root {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		if path != root {
+			if _, ok := skip[d.Name()]; ok {
+				log.Trace().Msgf("skipping ignored directory: %s", path)
+				return fs.SkipDir
+			}
+		}
+
+		return fn(path)
+	})
+}
+
+// modifiedSince returns the first of the given paths modified after the given time, if any.  Paths
+// that cannot be accessed are ignored.
+func modifiedSince(paths []string, t time.Time) (string, bool) {
+	for _, p := range paths {
+		stat, err := os.Stat(p)
+		if err != nil {
+			log.Trace().Msgf("unable to determine modification time: %s: %v", p, err)
+			continue
+		}
+
+		if stat.ModTime().After(t) {
+			return p, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FindGitDir searches for the git directory of the repository containing the specified path,
+// starting from the path and moving upwards through the directory tree.  A .git file, as created for
+// worktrees and submodules, is followed to the git directory it points to.  The function returns the
+// absolute path to the git directory if found, or an error if not found.
+func FindGitDir(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		gitPath := filepath.Join(path, ".git")
+		stat, err := os.Stat(gitPath)
+		if os.IsNotExist(err) {
+			parentDir := filepath.Dir(path)
+			if parentDir == path {
+				return "", fmt.Errorf("git directory not found")
+			}
+			path = parentDir
+			continue
+		} else if err != nil {
+			return "", err
+		} else if stat.IsDir() {
+			return gitPath, nil
+		}
+
+		return readGitFile(gitPath)
+	}
+}
+
+// readGitFile returns the absolute path to the git directory designated by the 'gitdir' line of
+// the given .git file.
+func readGitFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	dir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+	if !ok {
+		return "", fmt.Errorf("invalid .git file: %s", path)
+	}
+
+	dir = strings.TrimSpace(dir)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(path), dir)
+	}
+	return dir, nil
+}
@@ -16,9 +16,10 @@ type GoMod struct {
 }
 
 // NewGoMod initializes a GoMod struct with the path to the go.mod file.  It takes a directory path
-// as input and finds the go.mod file by traversing up the directory tree.
-func NewGoMod(path string) (*GoMod, error) {
-	goModPath, err := FindGoModFile(path)
+// as input and finds the go.mod file by traversing up the directory tree, searching at most limit
+// directories unless limit is zero.
+func NewGoMod(path string, limit int) (*GoMod, error) {
+	goModPath, err := FindGoModFile(path, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -69,20 +70,31 @@ func (gm *GoMod) Module() (string, error) {
 
 // FindGoModFile searches for a go.mod file starting from the specified directory path and moving
 // upwards through the directory tree until the file is found or the root of the file system is
-// reached.  The function returns the absolute path to the go.mod file if found, or an error if not
-// found.
-func FindGoModFile(path string) (string, error) {
+// reached.  Symbolic links in the path are resolved first, so that the search follows the directory
+// tree the path actually resides in, as the go command does.  A non-zero limit caps the number of
+// directories searched, starting with the given one, so that an unrelated module far up the tree is
+// not mistaken for the one containing the path.  The function returns the absolute path to the
+// go.mod file if found, or an error if not found.
+func FindGoModFile(path string, limit int) (string, error) {
 	path, err := filepath.Abs(path)
 	if err != nil {
 		return "", err
 	}
 
-	for {
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+
+	start := path
+	for searched := 1; ; searched++ {
 		goModPath := filepath.Join(path, "go.mod")
 		if _, err := os.Stat(goModPath); os.IsNotExist(err) {
 			parentDir := filepath.Dir(path)
 			if parentDir == path {
 				return "", fmt.Errorf("go.mod file not found")
+			} else if limit > 0 && searched >= limit {
+				return "", fmt.Errorf("go.mod file not found within %d directories of %s", limit, start)
 			}
 			path = parentDir
 			continue
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// symlinkedModule creates a module in a temporary directory with a sub directory, and returns the
+// resolved root of the module along with a symlink to it.
+func symlinkedModule(t *testing.T) (root, link string) {
+	t.Helper()
+
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root = filepath.Join(base, "real")
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/m\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	link = filepath.Join(base, "link")
+	if err := os.Symlink(root, link); err != nil {
+		t.Fatal(err)
+	}
+	return root, link
+}
+
+func TestFindGoModFileSymlinkedDir(t *testing.T) {
+	root, link := symlinkedModule(t)
+
+	got, err := FindGoModFile(filepath.Join(link, "sub"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "go.mod"); got != want {
+		t.Errorf("FindGoModFile() = %q, want %q", got, want)
+	}
+}
+
+func TestFindGoModFileLimit(t *testing.T) {
+	root, _ := symlinkedModule(t)
+
+	if _, err := FindGoModFile(filepath.Join(root, "sub"), 1); err == nil {
+		t.Error("go.mod found beyond the search limit")
+	}
+	if _, err := FindGoModFile(filepath.Join(root, "sub"), 2); err != nil {
+		t.Errorf("go.mod not found within the search limit: %v", err)
+	}
+}
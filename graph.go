@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+// graphCmd defines the command that prints the import graph of the monitored packages.
+var graphCmd = &cobra.Command{
+	Use:   "graph [flags] [path]",
+	Short: "Print the import graph of the monitored packages in Graphviz DOT format.",
+	Long: `Prints the import graph of the packages that would be monitored for PATH, in Graphviz DOT format, to standard output.  Nodes are package paths and edges are the import relationships between them.  The graph is subject to the same inclusion rules as monitoring, so external dependencies only appear when --include-external-deps is given.
+
+If PATH is not specified, the current working directory is assumed.  The output can be rendered with, for instance, 'godepmon graph | dot -Tpng -o graph.png'.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  runGraph,
+}
+
+// init registers the graph command with the root command.
+func init() {
+	rootCmd.AddCommand(graphCmd)
+}
+
+// runGraph is the main execution logic of the graph command.
+func runGraph(cmd *cobra.Command, args []string) {
+	path := "."
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	graph, err := newDepWalker().Graph(path)
+	if err != nil {
+		Fatal("Failed to determine dependencies\n%v", err)
+	}
+
+	if err := graph.WriteDOT(os.Stdout); err != nil {
+		Fatal("Failed to write graph\n%v", err)
+	}
+}
+
+// WriteDOT writes the graph to w in Graphviz DOT format.  Nodes and edges are written in sorted
+// order so that the output is stable.
+func (g Graph) WriteDOT(w io.Writer) error {
+	ids := make([]string, 0, len(g))
+	for id := range g {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph godepmon {")
+	for _, id := range ids {
+		fmt.Fprintf(bw, "\t%q;\n", id)
+	}
+	for _, id := range ids {
+		for _, to := range g[id] {
+			fmt.Fprintf(bw, "\t%q -> %q;\n", id, to)
+		}
+	}
+	fmt.Fprintln(bw, "}")
+
+	return bw.Flush()
+}
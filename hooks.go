@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/rs/zerolog/log"
+)
+
+// HookError represents an error that occurs when a hook command fails to run or exits with a
+// non-zero status.
+type HookError struct {
+	Name    string
+	Command string
+	Err     error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("The %s hook '%s' failed\n%v", e.Name, e.Command, e.Err)
+}
+
+// runHook runs the given hook command to completion in the specified working directory.  The
+// hook's output is forwarded to godepmon's standard output and error streams.  An error is returned
+// if the hook cannot be run or exits with a non-zero status.
+func runHook(ctx context.Context, name string, cwd string, command string) error {
+	args, err := splitCommand(command)
+	if err != nil {
+		return &HookError{Name: name, Command: command, Err: err}
+	} else if len(args) == 0 {
+		return &HookError{Name: name, Command: command, Err: &EmptyCommandError{}}
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = cwd
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	log.Info().Msgf("running %s hook: %s", name, command)
+	if err := cmd.Run(); err != nil {
+		return &HookError{Name: name, Command: command, Err: err}
+	}
+
+	log.Debug().Msgf("%s hook completed", name)
+	return nil
+}
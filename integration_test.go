@@ -0,0 +1,121 @@
+//go:build integration
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// buildGodepmon builds the godepmon binary into a temporary directory and returns its path.
+func buildGodepmon(t *testing.T) string {
+	t.Helper()
+
+	bin := filepath.Join(t.TempDir(), "godepmon")
+	out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput()
+	if err != nil {
+		t.Fatalf("unable to build godepmon: %v\n%s", err, out)
+	}
+	return bin
+}
+
+// writeFiles writes the given files, keyed by their path relative to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// expectLine reads lines from the channel until one contains want, failing the test if none does
+// before the timeout elapses.
+func expectLine(t *testing.T, lines <-chan string, want string, timeout time.Duration) {
+	t.Helper()
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("output ended before a line containing %q", want)
+			}
+			if strings.Contains(line, want) {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("no line containing %q within %s", want, timeout)
+		}
+	}
+}
+
+// TestProtoRegeneration checks that --watch-ext and --before compose: a change to a .proto file in
+// a package directory runs the generator before the command is restarted with the regenerated code.
+func TestProtoRegeneration(t *testing.T) {
+	bin := buildGodepmon(t)
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The generator stands in for a tool such as buf, turning the first message of the .proto file
+	// into a Go constant.
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/proto\n\ngo 1.21\n",
+		"api.proto": "syntax = \"proto3\";\n\nmessage Hello {}\n",
+		"gen.sh": "printf 'package main\\n\\nconst message = \"%s\"\\n' " +
+			"\"$(sed -n 's/^message \\([A-Za-z]*\\).*/\\1/p' api.proto | head -n 1)\" > gen.go\n",
+		"gen.go":  "package main\n\nconst message = \"\"\n",
+		"main.go": "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println(\"generated: \" + message) }\n",
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, bin, "--watch-ext", "proto", "--before", "sh gen.sh", ".", "--",
+		"go", "run", ".")
+	cmd.Dir = dir
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGINT) }
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// godepmon is interrupted, and its output read to the end, before the test completes.
+	lines := make(chan string)
+	done := make(chan struct{})
+	defer func() { <-done }()
+	defer cmd.Wait()
+	defer cancel()
+	go func() {
+		defer close(done)
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			t.Log(scanner.Text())
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	expectLine(t, lines, "generated: Hello", time.Minute)
+
+	writeFiles(t, dir, map[string]string{
+		"api.proto": "syntax = \"proto3\";\n\nmessage Goodbye {}\n",
+	})
+	expectLine(t, lines, "generated: Goodbye", time.Minute)
+}
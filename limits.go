@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SizeError represents an error that occurs when a size, such as a memory limit, is malformed.
+type SizeError struct {
+	Value string
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("Invalid size '%s': must be a number of bytes, optionally followed by K, M, G "+
+		"or T", e.Value)
+}
+
+// resourceLimits holds the limits on the resources the command may use.  Zero values stand for no
+// limit.
+type resourceLimits struct {
+	// Memory is the maximum size of the virtual memory of every process of the command, in bytes.
+	Memory uint64
+	// CPU is the maximum CPU time every process of the command may consume.
+	CPU time.Duration
+}
+
+// isZero reports whether no limit is set.
+func (l resourceLimits) isZero() bool {
+	return l.Memory == 0 && l.CPU == 0
+}
+
+// cpuSeconds returns the CPU time limit in whole seconds, rounded up, as rlimits are expressed.
+func (l resourceLimits) cpuSeconds() uint64 {
+	return uint64((l.CPU + time.Second - 1) / time.Second)
+}
+
+// ParseSize parses a size in bytes, optionally followed by one of the binary multiples K, M, G or T,
+// themselves optionally followed by "B" or "iB", e.g. "512M" or "2GiB".
+func ParseSize(s string) (uint64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+
+	shift := 0
+	if n := len(value); n > 0 {
+		if i := strings.IndexByte("KMGT", value[n-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			value = value[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || n == 0 || n > math.MaxUint64>>shift {
+		return 0, &SizeError{Value: s}
+	}
+	return n << shift, nil
+}
@@ -0,0 +1,89 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// limitsSupported reports whether resource limits can be applied to the command on this platform.
+const limitsSupported = true
+
+// limitArg specifies the argument with which godepmon is started in place of the command by
+// limitCommand.  It is followed by the resource limits to apply, then by the program to execute and
+// its arguments.
+const limitArg = "__limit"
+
+// limitCommand arranges for the given command, which must not have been started yet, to run with the
+// given resource limits from its very first instruction.  Since Go offers no way of setting rlimits
+// in a child process between fork and exec, the command is started as godepmon itself, which applies
+// the limits to itself and then executes the program in its place, by way of execLimited.
+func limitCommand(cmd *exec.Cmd, limits resourceLimits) error {
+	// Starting the command reports the program not being found.
+	if cmd.Err != nil {
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	value := fmt.Sprintf("%d,%d", limits.Memory, limits.cpuSeconds())
+	cmd.Args = append([]string{exe, limitArg, value, cmd.Path}, cmd.Args...)
+	cmd.Path = exe
+	return nil
+}
+
+// execLimited executes the program given by the arguments of godepmon, followed by the program's
+// own arguments, with the resource limits given before it, should godepmon have been started in
+// place of the command by limitCommand.  It returns otherwise.  The limits are applied as rlimits,
+// which the program and the processes it starts inherit.  The CPU time limit is enforced by SIGXCPU,
+// followed by SIGKILL a second later should the process handle the former.
+func execLimited() {
+	if len(os.Args) < 2 || os.Args[1] != limitArg {
+		return
+	}
+
+	var memory, cpu uint64
+	if len(os.Args) < 5 {
+		Error("Missing arguments to %s", limitArg)
+		os.Exit(126)
+	} else if _, err := fmt.Sscanf(os.Args[2], "%d,%d", &memory, &cpu); err != nil {
+		Error("Invalid resource limits: %s", os.Args[2])
+		os.Exit(126)
+	}
+
+	if memory > 0 {
+		if err := unix.Setrlimit(unix.RLIMIT_AS, &unix.Rlimit{Cur: memory, Max: memory}); err != nil {
+			Error("Unable to apply memory limit\n%v", err)
+			os.Exit(126)
+		}
+	}
+	if cpu > 0 {
+		if err := unix.Setrlimit(unix.RLIMIT_CPU, &unix.Rlimit{Cur: cpu, Max: cpu + 1}); err != nil {
+			Error("Unable to apply CPU time limit\n%v", err)
+			os.Exit(126)
+		}
+	}
+
+	err := syscall.Exec(os.Args[3], os.Args[4:], os.Environ())
+	Error("Unable to execute '%s'\n%v", os.Args[3], err)
+	// Exit codes as used by shells for commands that are not found or cannot be executed.
+	if errors.Is(err, syscall.ENOENT) {
+		os.Exit(127)
+	}
+	os.Exit(126)
+}
+
+// exceededCPULimit reports whether the given wait status indicates that the process was killed
+// for exceeding its CPU time limit.
+func exceededCPULimit(ws syscall.WaitStatus) bool {
+	return ws.Signaled() && ws.Signal() == syscall.SIGXCPU
+}
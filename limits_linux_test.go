@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitCommand(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "grep -E '^Max (address space|cpu time)' /proc/self/limits; "+
+		`echo "$0" "$@"`, "name", "first", "second")
+	limits := resourceLimits{Memory: 512 << 20, CPU: 1500 * time.Millisecond}
+	if err := limitCommand(cmd, limits); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+	want := []string{
+		"Max cpu time 2 3 seconds",
+		"Max address space 536870912 536870912 bytes",
+		"name first second",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("limits of the command:\n%s\nwant:\n%s", strings.Join(lines, "\n"),
+			strings.Join(want, "\n"))
+	}
+}
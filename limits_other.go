@@ -0,0 +1,25 @@
+//go:build !linux
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// limitsSupported reports that resource limits cannot be applied to the command, since they are
+// only supported on Linux.
+const limitsSupported = false
+
+// limitCommand does nothing since resource limits are only supported on Linux.
+func limitCommand(cmd *exec.Cmd, limits resourceLimits) error {
+	return nil
+}
+
+// execLimited does nothing since resource limits are only supported on Linux.
+func execLimited() {}
+
+// exceededCPULimit reports false since resource limits are only supported on Linux.
+func exceededCPULimit(ws syscall.WaitStatus) bool {
+	return false
+}
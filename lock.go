@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+// LockHeldError represents an error that occurs when the lock file is held by another process.
+type LockHeldError struct {
+	Path string
+	Pid  int
+}
+
+func (e *LockHeldError) Error() string {
+	if e.Pid == 0 {
+		return fmt.Sprintf("Another godepmon instance holds the lock file '%s'", e.Path)
+	}
+	return fmt.Sprintf("Another godepmon instance (PID %d) holds the lock file '%s'", e.Pid, e.Path)
+}
+
+// LockError wraps an error encountered while creating or locking the lock file.
+type LockError struct {
+	Path string
+	Err  error
+}
+
+func (e *LockError) Error() string {
+	return fmt.Sprintf("Failed to lock '%s'\n%v", e.Path, e.Err)
+}
+
+// lockFile represents an exclusive, advisory lock held on a file containing the PID of the holder.
+// Since the lock is tied to the open file, it is released by the operating system should the
+// process die without releasing it, so a crashed instance never leaves a stale lock behind.
+type lockFile struct {
+	path string
+	file *os.File
+}
+
+// AcquireLock creates the lock file at the specified path, if needed, and acquires an exclusive lock
+// on it without blocking.  A *LockHeldError is returned if the lock is held by another process.
+func AcquireLock(path string) (*lockFile, error) {
+	for {
+		file, err := lockPath(path)
+		if err != nil {
+			return nil, err
+		}
+
+		// The holder removes the file on release, so the file locked may since have been
+		// unlinked, and another process may have created and locked a new one in its place.
+		// The lock is only held if the file locked is still the one at the path.
+		if locked, err := file.Stat(); err != nil {
+			file.Close()
+			return nil, &LockError{Path: path, Err: err}
+		} else if current, err := os.Stat(path); err != nil || !os.SameFile(locked, current) {
+			log.Debug().Msgf("lock file replaced while locking, retrying: %s", path)
+			file.Close()
+			continue
+		}
+
+		if err := file.Truncate(0); err != nil {
+			file.Close()
+			return nil, &LockError{Path: path, Err: err}
+		}
+
+		if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+			file.Close()
+			return nil, &LockError{Path: path, Err: err}
+		}
+
+		log.Debug().Msgf("acquired lock: %s", path)
+		return &lockFile{path: path, file: file}, nil
+	}
+}
+
+// lockPath opens the file at the specified path, creating it if needed, and acquires an exclusive
+// lock on it without blocking.
+func lockPath(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, &LockError{Path: path, Err: err}
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, &LockHeldError{Path: path, Pid: readPid(file)}
+		}
+		return nil, &LockError{Path: path, Err: err}
+	}
+
+	return file, nil
+}
+
+// Release removes the lock file and releases the lock.  The file is removed while the lock is still
+// held, so that a process that opened it beforehand and locks it afterwards finds it unlinked and
+// tries again with a new file, as AcquireLock does.
+func (l *lockFile) Release() error {
+	if err := os.Remove(l.path); err != nil {
+		log.Debug().Msgf("error removing lock file: %s: %v", l.path, err)
+	}
+
+	log.Debug().Msgf("released lock: %s", l.path)
+	return l.file.Close()
+}
+
+// readPid returns the PID recorded in the lock file, or 0 if it cannot be determined.
+func readPid(file *os.File) int {
+	buf := make([]byte, 32)
+	n, _ := file.ReadAt(buf, 0)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
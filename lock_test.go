@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquireLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "godepmon.lock")
+
+	lock, err := AcquireLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var held *LockHeldError
+	if _, err := AcquireLock(path); !errors.As(err, &held) {
+		t.Fatalf("second AcquireLock() error = %v, want LockHeldError", err)
+	} else if held.Pid != os.Getpid() {
+		t.Errorf("LockHeldError.Pid = %d, want %d", held.Pid, os.Getpid())
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("lock file not removed on release: %v", err)
+	}
+
+	lock, err = AcquireLock(path)
+	if err != nil {
+		t.Fatalf("AcquireLock() after release: %v", err)
+	}
+	lock.Release()
+}
@@ -4,22 +4,46 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 const (
 	// defaultCommand defines the default command to execute when changes are detected and no
 	// specific command has been provided by the user.
 	defaultCommand = "go run ."
+
+	// defaultBuildGate defines the build gate command used when --build-first is given.
+	defaultBuildGate = "go build -o " + os.DevNull + " ./..."
+
+	// selfChangeGrace specifies how long after the command exits changes are still attributed to
+	// it when --ignore-self-changes is given.
+	selfChangeGrace = 500 * time.Millisecond
+
+	// teardownTimeout specifies how long the teardown command given by --teardown may run before
+	// it is killed.
+	teardownTimeout = 30 * time.Second
+
+	// terminatedExitCode specifies the status with which godepmon exits once --max-runtime is
+	// reached if the last run was terminated rather than exiting of its own accord, such as when it
+	// was still running at the time, as timeout(1) does.
+	terminatedExitCode = 124
 )
 
 // rootCmd defines the base command of godepmon.
@@ -30,8 +54,9 @@ var rootCmd = &cobra.Command{
 
 The tool accepts an optional PATH as an argument, which specifies the Go package to monitor; and a COMMAND, which specifies the command to run when a change is detected. Flags can be used to customize the monitoring and execution behavior, making Godepmon a flexible tool for various development scenarios.
 
-If PATH is not specified, the current working directory is assumed.  If COMMAND is not specified, 'go run .' is executed.  If intending to specify COMMAND, make sure PATH is given.`,
-	Run: run,
+If PATH is not specified, the current working directory is assumed.  If COMMAND is not specified or is empty, the default command is executed: the value of the GODEPMON_DEFAULT_CMD environment variable or the command of the configuration file if set, and 'go run .' otherwise.  If intending to specify COMMAND, make sure PATH is given.`,
+	Args: cobra.ArbitraryArgs,
+	Run:  run,
 }
 
 // programFlags defines the flags that can be passed to godepmon via the command line.  It allows
@@ -39,30 +64,302 @@ If PATH is not specified, the current working directory is assumed.  If COMMAND
 // monitoring process and adjusting verbosity.
 type programFlags struct {
 	includeExternalDeps bool
+	watchModCache       []string
+	followSymlinks      bool
+	poll                bool
+	includeTests        bool
+	fullReload          bool
+	ignoreDirs          []string
+	restartJitter       time.Duration
+	resolveInterval     time.Duration
+	commandStdin        string
+	watchRoot           bool
+	startRetries        int
+	maxConcurrent       int
+	configFile          string
+	maxRuntime          time.Duration
+	watchExtensions     []string
+	beforeHook          string
+	setup               string
+	teardown            string
+	debounce            time.Duration
+	waitForChange       bool
+	failFastBoot        bool
+	bootWindow          time.Duration
+	timeout             time.Duration
+	debouncePerFile     bool
+	warmup              time.Duration
+	runs                int
+	failFast            bool
+	lockFile            string
+	pattern             string
+	pkgs                []string
+	jsonEvents          bool
+	watchTestdata       bool
+	buildGate           string
+	buildFirst          bool
+	logTimestamps       bool
+	logJSON             bool
+	modMode             string
+	pipe                string
+	onErrorCommand      string
+	ignoreSelfChanges   bool
+	eventBuffer         int
+	goFilesOnly         bool
+	task                string
+	shutdownSignals     []string
+	restartIfChanged    bool
+	procTitle           bool
+	watchGitHead        bool
+	noRecursiveDeps     bool
+	contentHash         bool
+	rerunOnSaveOnly     bool
+	reloadConfig        bool
+	pty                 bool
+	tailOnFail          int
+	changedFile         bool
+	commandArgsFile     string
+	envFile             string
+	memLimit            string
+	cpuLimit            time.Duration
+	shell               bool
+	commandShellPath    string
+	manifest            string
+	summaryOnExit       bool
+	nestedModules       bool
+	watchAllPackages    bool
+	reloadSignal        string
+	metricsAddr         string
+	killSequence        string
+	printConfig         bool
+	file                string
+	cleanRun            bool
+	logOriginalCommand  bool
+	since               time.Duration
+	fast                bool
+	ignoreRemoves       bool
+	outputGlobs         []string
+	separator           bool
+	chdir               string
+	watchSelf           bool
+	moduleSearchLimit   int
+	excludeGenerated    bool
 	verbose             int
 }
 
 // flags holds the actual values of the command line flags after they have been parsed.
 var flags programFlags = programFlags{}
 
+// exitCode holds the status with which godepmon exits once the root command returns.
+var exitCode int
+
 // init initializes the command line interface, setting up flags and adjusting the logging
 // configuration based on user input.
 func init() {
-	log.Logger = log.Output(zerolog.ConsoleWriter{
-		Out:             os.Stdout,
-		FormatTimestamp: func(i interface{}) string { return "" },
-		NoColor:         false,
-	})
+	log.Logger = log.Output(newConsoleWriter(false))
 
-	f := rootCmd.Flags()
-	f.BoolVar(&flags.includeExternalDeps, "include-external-deps", false,
+	// Flags affecting dependency resolution are shared with subcommands.
+	pf := rootCmd.PersistentFlags()
+	pf.BoolVar(&flags.includeExternalDeps, "include-external-deps", false,
 		"Also include external dependencies (default: include module imports only)")
+	pf.StringSliceVar(&flags.watchModCache, "watch-mod-cache", nil,
+		"Also include the external package with the given import path and the packages beneath it (repeatable)")
+	pf.BoolVar(&flags.includeTests, "include-tests", false,
+		"Also include test files and the packages imported by tests")
+	pf.StringVar(&flags.pattern, "pattern", defaultPattern,
+		"Package pattern, relative to PATH, whose packages and dependencies are watched")
+	pf.StringSliceVar(&flags.pkgs, "pkg", nil,
+		"Package pattern, relative to PATH, watched along with those of other --pkg flags instead of --pattern (repeatable)")
+	rootCmd.MarkFlagsMutuallyExclusive("pkg", "pattern")
+	pf.StringVar(&flags.modMode, "mod", "",
+		"Module download mode used when resolving dependencies: mod, readonly or vendor (default: chosen by the go command)")
+	pf.BoolVar(&flags.noRecursiveDeps, "no-recursive-deps", false,
+		"Only include the direct imports of the monitored packages rather than all their dependencies")
+	pf.StringSliceVar(&flags.watchExtensions, "watch-ext", nil,
+		"Also watch files with the given extension in the directory of every package (repeatable)")
+	pf.IntVar(&flags.moduleSearchLimit, "module-search-limit", 0,
+		"Maximum number of directories searched for go.mod, starting with PATH and moving up (default: no limit)")
+	pf.BoolVar(&flags.excludeGenerated, "exclude-generated", false,
+		"Do not watch Go files marked as generated by a 'Code generated ... DO NOT EDIT.' comment")
+	pf.BoolVar(&flags.fast, "fast", false,
+		"Resolve dependencies without loading external packages, which is faster on large dependency trees")
+
+	f := rootCmd.Flags()
+	f.BoolVar(&flags.fullReload, "full-reload", false,
+		"Keep watching across runs and re-resolve dependencies on every change")
+	f.BoolVar(&flags.watchTestdata, "watch-testdata", false,
+		"Also watch the testdata directories of monitored packages, recursively")
+	f.StringSliceVar(&flags.ignoreDirs, "ignore-dir", defaultIgnoreDirs,
+		"Name of a directory to skip, with its subtree, when walking directory trees (repeatable)")
+	f.DurationVar(&flags.restartJitter, "restart-jitter", 0,
+		"Delay each restart by a random duration up to the given value (e.g. 2s)")
+	f.DurationVar(&flags.resolveInterval, "resolve-interval", 0,
+		"Re-resolve dependencies at the given interval (e.g. 30s), regardless of events, and update the watched files")
+	f.StringVar(&flags.commandStdin, "command-stdin", "",
+		"Content to feed to the command's standard input on every run; use @FILE to read it from a file")
+	f.BoolVar(&flags.watchRoot, "watch-root", false,
+		"Watch the whole module containing PATH while still running the command from PATH")
+	f.IntVar(&flags.maxConcurrent, "max-concurrent", 1,
+		"Maximum number of rule commands run simultaneously; 0 removes the limit")
+	f.IntVar(&flags.startRetries, "start-retries", 0,
+		"Number of times to retry, with backoff, starting a command that fails to start")
+	f.StringVar(&flags.configFile, "config", "",
+		"Path to the configuration file (default: "+defaultConfigFile+" in the current directory, if present)")
+	f.DurationVar(&flags.maxRuntime, "max-runtime", 0,
+		"Stop monitoring and terminate the command after the given duration (e.g. 10m)")
+	f.StringVar(&flags.beforeHook, "before", "",
+		"Command to run to completion before every run; the run is skipped if it fails")
+	f.StringVar(&flags.setup, "setup", "",
+		"Command to run to completion once before watching begins; godepmon exits if it fails")
+	f.StringVar(&flags.teardown, "teardown", "",
+		"Command to run to completion once on shutdown, after the command is terminated")
+	f.DurationVar(&flags.debounce, "debounce", defaultDebounceDelay,
+		"Time to wait for events to settle before processing them; 0 processes every event immediately")
+	f.BoolVar(&flags.debouncePerFile, "debounce-per-file", false,
+		"Debounce events independently for every file instead of with a single shared timer")
+	f.DurationVar(&flags.warmup, "warmup", 0,
+		"Ignore changes for the given duration after watching starts (e.g. 1s)")
+	f.IntVar(&flags.runs, "runs", 0,
+		"Run the command the given number of times in succession, without watching, then exit")
+	f.BoolVar(&flags.failFast, "fail-fast", false,
+		"Stop at the first failing run (requires --runs)")
+	f.StringVar(&flags.lockFile, "lock-file", "",
+		"Lock the given file to prevent concurrent instances using the same lock file")
+	f.BoolVar(&flags.jsonEvents, "json", false,
+		"Write lifecycle events as JSON objects, one per line, to standard error")
+	f.StringVar(&flags.buildGate, "build-gate", "",
+		"Command to run on change before terminating the running command; the restart is skipped if it fails")
+	f.BoolVar(&flags.buildFirst, "build-first", false,
+		"Restart only if '"+defaultBuildGate+"' succeeds, keeping the running command otherwise")
+	rootCmd.MarkFlagsMutuallyExclusive("build-gate", "build-first")
+	f.StringVar(&flags.pipe, "pipe", "",
+		"Filter command through which the command's combined standard output and standard error are piped")
+	f.StringVar(&flags.onErrorCommand, "on-error-command", "",
+		"Command to run whenever the command exits with a non-zero status")
+	f.BoolVar(&flags.ignoreSelfChanges, "ignore-self-changes", false,
+		"Ignore changes made while the command runs, and shortly after it exits, so its own outputs do not trigger reruns")
+	f.IntVar(&flags.eventBuffer, "event-buffer", defaultEventBuffer,
+		"Number of file system events buffered between reading and processing them, to absorb bursts of changes")
+	f.BoolVar(&flags.goFilesOnly, "watch-go-files-only", false,
+		"Only react to changes to Go source files that are resolved dependencies, whatever else is watched")
+	f.StringVar(&flags.task, "task", "",
+		"Name of the task, defined in the configuration file, whose command runs when no COMMAND is given")
+	f.StringSliceVar(&flags.shutdownSignals, "shutdown-signals", defaultShutdownSignals,
+		"Signals that stop monitoring and terminate the command; default ones left out are ignored")
+	f.BoolVar(&flags.restartIfChanged, "restart-if-changed", false,
+		"Restart only if building the command's package produces a different binary than the running one")
+	f.BoolVar(&flags.procTitle, "proctitle", false,
+		"Show the monitored path and command in the process title, as listed by ps and top (Linux only)")
+	f.BoolVar(&flags.watchGitHead, "watch-git-head", false,
+		"Also watch the HEAD of the git repository containing PATH, so switching branches triggers a single rerun")
+	f.BoolVar(&flags.contentHash, "content-hash", false,
+		"Ignore changes that leave the content of files unchanged, such as saving a file without edits")
+	f.BoolVar(&flags.rerunOnSaveOnly, "rerun-on-save-only", false,
+		"Collapse the events editors produce when saving a file into a single change, ignoring their temporary files")
+	f.BoolVar(&flags.pty, "pty", false,
+		"Attach the command to a pseudo-terminal, so it keeps the output formatting it uses in a terminal")
+	rootCmd.MarkFlagsMutuallyExclusive("pty", "command-stdin")
+	f.IntVar(&flags.tailOnFail, "tail-on-fail", 0,
+		"Print the given number of lines at the end of the command's output again when it exits with a non-zero status")
+	f.BoolVar(&flags.changedFile, "changed-file", false,
+		"Write the changed paths to a temporary file whose path is given to the command in GODEPMON_CHANGED_FILE")
+	f.StringVar(&flags.commandArgsFile, "command-args-file", "",
+		"File holding the program and arguments of the command, one per line, used instead of COMMAND")
+	f.StringVar(&flags.envFile, "env-file", "",
+		"Dotenv file whose variables are added to the command's environment; changes to it rerun the command")
+	f.StringVar(&flags.memLimit, "mem-limit", "",
+		"Maximum virtual memory of every process of the command, e.g. 512M or 2G (Linux only)")
+	f.DurationVar(&flags.cpuLimit, "cpu-limit", 0,
+		"Maximum CPU time every process of the command may consume, e.g. 30s (Linux only)")
+	f.BoolVar(&flags.shell, "shell", false,
+		"Run COMMAND through a shell, so that it may use pipes, redirections and other shell syntax")
+	f.StringVar(&flags.commandShellPath, "command-shell-path", "",
+		"Shell used to run COMMAND with --shell (default: $SHELL, or /bin/sh if unset)")
+	rootCmd.MarkFlagsMutuallyExclusive("shell", "command-args-file")
+	f.BoolVar(&flags.failFastBoot, "fail-fast-boot", false,
+		"Exit with the command's status if it fails within --boot-window of its first start, and keep watching otherwise")
+	f.DurationVar(&flags.bootWindow, "boot-window", defaultBootWindow,
+		"Time after the first start of the command within which a failure ends godepmon with --fail-fast-boot")
+	f.BoolVar(&flags.waitForChange, "wait-for-change", false,
+		"Wait for a single change without running any command, then exit with status 0, or 124 if --timeout elapses first")
+	f.DurationVar(&flags.timeout, "timeout", 0,
+		"Maximum time to wait for a change with --wait-for-change (e.g. 30s; default: no limit)")
+	f.BoolVar(&flags.summaryOnExit, "summary-on-exit", false,
+		"Print the number of runs that passed, failed or were terminated when godepmon exits")
+	f.BoolVar(&flags.nestedModules, "nested-modules", false,
+		"Also watch the packages of all modules nested beneath PATH, which have go.mod files of their own")
+	f.BoolVar(&flags.watchAllPackages, "watch-all-packages", false,
+		"Also watch every package of the module, whether or not it is imported by the monitored packages")
+	f.StringVar(&flags.reloadSignal, "reload-signal", "",
+		"Signal sent to the running command on change so it reloads itself, instead of restarting it")
+	f.StringVar(&flags.metricsAddr, "metrics-addr", "",
+		"Address, such as localhost:9090, on which to serve Prometheus metrics at /metrics")
+	f.StringVar(&flags.killSequence, "kill-sequence", "",
+		"Signals sent in turn to terminate the command, with the time to wait after each, e.g. SIGTERM:2s,SIGINT:1s,SIGKILL")
+	f.BoolVar(&flags.printConfig, "print-config", false,
+		"Print the effective configuration, after applying the configuration file and defaults, as JSON and exit")
+	f.StringVar(&flags.file, "file", "",
+		"Watch a single Go file, which may reside outside of any module, instead of PATH; COMMAND defaults to 'go run FILE'")
+	rootCmd.MarkFlagsMutuallyExclusive("file", "pattern")
+	rootCmd.MarkFlagsMutuallyExclusive("file", "pkg")
+	rootCmd.MarkFlagsMutuallyExclusive("file", "nested-modules")
+	f.StringVar(&flags.manifest, "manifest", "",
+		"File listing the paths to watch, one per line, instead of resolving the dependencies of PATH")
+	rootCmd.MarkFlagsMutuallyExclusive("manifest", "file")
+	f.BoolVar(&flags.cleanRun, "clean-run", false,
+		"Run the command in a fresh temporary copy of the module, made before every run, to keep artifacts out of the source tree")
+	f.BoolVar(&flags.logOriginalCommand, "log-original-command", false,
+		"Log the command as given when starting it, rather than with the absolute path of its program")
+	f.DurationVar(&flags.since, "since", 0,
+		"Skip the initial run unless a watched file was modified within the given duration, e.g. 1h")
+	f.BoolVar(&flags.ignoreRemoves, "ignore-removes", false,
+		"Do not rerun the command when watched files are removed")
+	f.StringSliceVar(&flags.outputGlobs, "output-glob", nil,
+		"Glob, relative to PATH, matching files the command produces, whose changes are ignored while it runs (repeatable)")
+	f.BoolVar(&flags.separator, "separator", false,
+		"Print a divider line stating what triggered the run before every start of the command")
+	f.StringVarP(&flags.chdir, "chdir", "C", "",
+		"Change to the given directory before doing anything else, so that relative paths are resolved from it")
+	f.BoolVar(&flags.watchSelf, "watch-self", false,
+		"Restart godepmon, and the command, with the new configuration whenever the configuration file changes")
+	f.BoolVar(&flags.reloadConfig, "reload-config", false,
+		"Re-read the command and rules from the configuration file before every run following a change")
+	rootCmd.MarkFlagsMutuallyExclusive("reload-config", "watch-self")
+	f.BoolVar(&flags.poll, "poll", false,
+		"Scan for changes periodically instead of relying on file system events (default: only on file systems known not to deliver them)")
+	f.BoolVar(&flags.followSymlinks, "follow-symlinks", false,
+		"Watch the targets of symlinked dependencies rather than the links themselves")
+
+	// The watch-only command watches files as the root command does, so it shares the flags that
+	// determine which files are watched and how changes to them are detected.
+	for _, name := range []string{"ignore-dir", "watch-testdata", "nested-modules",
+		"watch-all-packages", "manifest", "env-file", "resolve-interval", "poll", "event-buffer",
+		"watch-go-files-only", "watch-git-head", "content-hash", "rerun-on-save-only",
+		"ignore-removes", "debounce", "debounce-per-file", "warmup", "follow-symlinks"} {
+		watchOnlyCmd.Flags().AddFlag(f.Lookup(name))
+	}
 
 	rootCmd.PersistentFlags().
 		CountVarP(&flags.verbose, "verbose", "v",
 			"Increase verbosity. Use multiple times for more verbose output (up to three levels; e.g., -vvv).")
+	rootCmd.PersistentFlags().
+		BoolVar(&flags.logTimestamps, "log-timestamps", false, "Prefix log messages with a timestamp")
+	rootCmd.PersistentFlags().
+		BoolVar(&flags.logJSON, "log-json", false,
+			"Write log messages as JSON objects, one per line, to standard error")
+	rootCmd.MarkFlagsMutuallyExclusive("log-timestamps", "log-json")
+
+	registerCompletions()
 
 	cobra.OnInitialize(func() {
+		// JSON log messages go to standard error, apart from the command's standard output,
+		// and always carry a timestamp.
+		if flags.logJSON {
+			log.Logger = log.Output(os.Stderr)
+		} else if flags.logTimestamps {
+			log.Logger = log.Output(newConsoleWriter(true))
+		}
+
 		// Adjust the global logging level based on the verbosity count
 		switch flags.verbose {
 		case 0:
@@ -78,59 +375,729 @@ func init() {
 }
 
 func main() {
+	// godepmon may have been started in place of the command to apply resource limits to it.
+	execLimited()
+
 	if err := rootCmd.Execute(); err != nil {
 		Fatal("Fatal error occurred:\n%v", err)
 	}
+	if reexecDir != "" {
+		reexec(reexecDir)
+	}
+
+	os.Exit(exitCode)
 }
 
 // run is the main execution logic of the root command. It sets up signal handling for graceful
 // shutdown and orchestrates the monitoring and command execution process.
 func run(cmd *cobra.Command, args []string) {
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	if err := execute(cmd, args); err != nil {
+		Fatal(err.Error())
+	}
+}
+
+// execute implements run.  Flags are all validated before the lock is acquired and the setup hook
+// runs, exiting on error.  Errors arising from then on are returned instead, so that the teardown
+// hook, the release of the lock and the cleanup of the commander take place before exiting.
+func execute(cmd *cobra.Command, args []string) error {
+	if len(flags.shutdownSignals) == 0 {
+		Fatal("--shutdown-signals cannot be empty")
+	}
+	if flags.timeout < 0 {
+		Fatal("--timeout cannot be negative")
+	} else if flags.timeout != 0 && !flags.waitForChange {
+		Fatal("--timeout requires --wait-for-change")
+	}
+	if flags.runs == 0 && flags.failFast {
+		Fatal("--fail-fast requires --runs")
+	}
+	if flags.maxConcurrent < 0 {
+		Fatal("--max-concurrent cannot be negative")
+	}
+	if flags.debounce < 0 {
+		Fatal("--debounce cannot be negative")
+	}
 
-	path, command := processArgs(args)
-	runner := NewCommander(path, command)
-	defer runner.Terminate()
+	var reloadSignal syscall.Signal
+	if flags.reloadSignal != "" {
+		signals, err := ParseSignals([]string{flags.reloadSignal})
+		if err != nil {
+			Fatal(err.Error())
+		}
+		reloadSignal = signals[0].(syscall.Signal)
+	}
 
-	go func() {
-		<-signals
-		log.Info().Msg("received interrupt signal, terminating...")
-		if err := runner.Terminate(); err != nil {
+	// The directory is recorded before changing to another, since godepmon is executed anew from
+	// it with the same arguments should the configuration file change.
+	startDir, err := os.Getwd()
+	if err != nil {
+		Fatal("Unable to obtain current directory\n%v", err)
+	}
+	if flags.chdir != "" {
+		if err := os.Chdir(flags.chdir); err != nil {
+			Fatal("Unable to change to directory '%s'\n%v", flags.chdir, err)
+		}
+	}
+
+	ctx, stop, forwarded := shutdownContext()
+	defer stop()
+
+	if flags.maxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, flags.maxRuntime)
+		defer cancel()
+	}
+
+	cfg, err := LoadConfig(flags.configFile)
+	if err != nil {
+		Fatal(err.Error())
+	}
+
+	configChanged := func() bool { return false }
+	if flags.watchSelf && !flags.printConfig && flags.runs == 0 {
+		if ctx, configChanged, err = watchConfigFile(ctx, flags.configFile); err != nil {
+			Fatal(err.Error())
+		}
+	}
+
+	fallback, changeFallback, err := configCommands(cfg)
+	if err != nil {
+		Fatal(err.Error())
+	}
+
+	var path, command, changeCommand string
+	if flags.file != "" {
+		path, command = processFileArgs(args)
+	} else {
+		path, command = processArgs(args, "")
+	}
+	commandFromConfig := command == "" && flags.commandArgsFile == ""
+	if flags.commandArgsFile != "" {
+		if command != "" {
+			Fatal("--command-args-file cannot be combined with COMMAND")
+		}
+
+		argv, err := readArgsFile(flags.commandArgsFile)
+		if err != nil {
+			Fatal(err.Error())
+		}
+		command = joinCommand(argv)
+	} else if command == "" {
+		command, changeCommand = fallback, changeFallback
+	}
+
+	watchPath := path
+	if flags.watchRoot {
+		watchPath = moduleRoot(path)
+	}
+	if flags.buildFirst {
+		flags.buildGate = defaultBuildGate
+	}
+	if flags.printConfig {
+		printConfig(cmd, path, watchPath, command)
+		return nil
+	}
+	if flags.waitForChange {
+		exitCode = waitForChange(ctx, watchPath, flags.timeout)
+		return nil
+	}
+
+	logConfig(path, watchPath, command)
+	if flags.procTitle {
+		setProcTitle(fmt.Sprintf("godepmon [%s] %s", path, command))
+	}
+
+	runner := NewCommander(path, command, commanderOptions(cmd, path)...)
+	if err := runner.Preflight(); err != nil {
+		Fatal(err.Error())
+	}
+	forwardSignals(ctx, forwarded, runner)
+
+	var ignore func(string) bool
+	if flags.ignoreSelfChanges {
+		ignore = func(string) bool { return runner.Active(selfChangeGrace) }
+	} else if len(flags.outputGlobs) > 0 {
+		ignore = func(path string) bool { return runner.IsOutput(path, selfChangeGrace) }
+	}
+
+	// The monitor is created once the setup hook has run, since setup may modify the files whose
+	// modification times decide whether the first run is skipped.
+	var mx *metrics
+	var monitorOptions []monitorOption
+	if flags.runs == 0 {
+		if flags.metricsAddr != "" {
+			mx = NewMetrics(runner.ExitCode)
+		}
+		monitorOptions = []monitorOption{
+			WithWatcherOptions(watcherOptions(watchPath, ignore)...),
+			WithFullReload(flags.fullReload),
+			WithRestartJitter(flags.restartJitter),
+			WithStartRetries(flags.startRetries),
+			WithMaxConcurrent(flags.maxConcurrent),
+			WithFailFastBoot(bootWindow(cmd)),
+			WithBeforeHook(flags.beforeHook),
+			WithBuildGate(flags.buildGate),
+			WithErrorHook(flags.onErrorCommand),
+			WithRestartIfChanged(flags.restartIfChanged),
+			WithReloadSignal(reloadSignal),
+			WithSeparator(flags.separator),
+			WithRules(cfg.Rules),
+			WithChangeCommand(changeCommand),
+			WithConfigReload(configReloader(commandFromConfig)),
+			WithMetrics(mx),
+			WithEvents(newEventEmitter()),
+		}
+	}
+
+	if flags.lockFile != "" {
+		lock, err := AcquireLock(flags.lockFile)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
+
+	defer runner.Cleanup()
+	if flags.setup != "" {
+		if err := runHook(ctx, "setup", runner.Dir(), flags.setup); err != nil {
+			return err
+		}
+	}
+	if flags.teardown != "" {
+		defer runTeardown(runner.Dir(), flags.teardown)
+	}
+	if flags.summaryOnExit {
+		defer printSummary(runner.Stats)
+	}
+
+	skipFirstRun := false
+	if flags.since > 0 {
+		changed, err := changedRecently(watchPath, flags.since)
+		if err != nil {
+			return err
+		}
+		skipFirstRun = !changed
+	}
+	if flags.runs > 0 {
+		if skipFirstRun {
+			return nil
+		}
+		exitCode, err = runRepeatedly(ctx, runner, flags.runs, flags.failFast)
+		return err
+	}
+
+	if mx != nil {
+		srv, err := ServeMetrics(flags.metricsAddr, mx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close()
+	}
+
+	monitor := NewMonitor(watchPath, runner, append(monitorOptions, WithSkipFirstRun(skipFirstRun))...)
+	if err := monitor.Run(ctx); err != nil {
+		var berr *BootError
+		if !errors.As(err, &berr) {
+			return err
+		}
+		Error(berr.Error())
+		exitCode = berr.Code
+		return nil
+	}
+	if configChanged() {
+		reexecDir = startDir
+		return nil
+	}
+
+	// Stopping of godepmon's own accord, rather than at the user's request, propagates the exit
+	// status of the last run so that godepmon can serve as a build step.
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Warn().Msgf("maximum runtime of %s reached, terminated", flags.maxRuntime)
+		exitCode = maxRuntimeExitCode(runner.Stats())
+	}
+	return nil
+}
+
+// bootWindow returns the window following the first start of the command within which its failure
+// ends monitoring, or zero unless --fail-fast-boot is given.
+func bootWindow(cmd *cobra.Command) time.Duration {
+	if !flags.failFastBoot {
+		if cmd.Flags().Changed("boot-window") {
+			Fatal("--boot-window requires --fail-fast-boot")
+		}
+		return 0
+	} else if flags.bootWindow <= 0 {
+		Fatal("--boot-window must be positive")
+	}
+	return flags.bootWindow
+}
+
+// maxRuntimeExitCode returns the status with which godepmon exits once --max-runtime is reached,
+// given the statistics of the runs: the exit code of the last run, or terminatedExitCode if the
+// last run was terminated.
+func maxRuntimeExitCode(s RunStats) int {
+	if s.LastTerminated {
+		return terminatedExitCode
+	}
+	return s.LastExitCode
+}
+
+// resourceLimitsFromFlags returns the resource limits given by --mem-limit and --cpu-limit.
+func resourceLimitsFromFlags() resourceLimits {
+	var limits resourceLimits
+	if flags.memLimit != "" {
+		size, err := ParseSize(flags.memLimit)
+		if err != nil {
 			Fatal(err.Error())
 		}
-		os.Exit(0)
+		limits.Memory = size
+	}
+	if flags.cpuLimit < 0 {
+		Fatal("--cpu-limit cannot be negative")
+	}
+	limits.CPU = flags.cpuLimit
+
+	if !limits.isZero() && !limitsSupported {
+		Fatal("--mem-limit and --cpu-limit are only supported on Linux")
+	}
+	return limits
+}
+
+// envFilePath returns the absolute path of the environment file given by --env-file.
+func envFilePath() string {
+	path, err := filepath.Abs(flags.envFile)
+	if err != nil {
+		Fatal("Unable to determine path of environment file\n%v", err)
+	}
+	return path
+}
+
+// extraFiles returns the files watched in addition to the resolved dependencies: the environment
+// file of the command, if any.
+func extraFiles() []string {
+	if flags.envFile == "" {
+		return nil
+	}
+	return []string{envFilePath()}
+}
+
+// commandShell returns the shell through which the command is run with --shell: the one given by
+// --command-shell-path, otherwise the user's shell or /bin/sh.
+func commandShell() string {
+	if flags.commandShellPath != "" {
+		return flags.commandShellPath
+	} else if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "/bin/sh"
+}
+
+// configCommands returns the commands to execute on the first run and on every run following a
+// change, respectively, when none is given on the command line, as defined by the given
+// configuration or selected by the --task flag.  The built-in default is replaced with running the
+// file given by the --file flag, if any.
+func configCommands(cfg *Config) (string, string, error) {
+	startup, change := cfg.DefaultCommands()
+	if flags.task != "" {
+		task, err := cfg.Task(flags.task)
+		if err != nil {
+			return "", "", err
+		}
+		startup, change = task, task
+	}
+
+	if flags.file != "" {
+		if startup == defaultCommand {
+			startup = "go run " + filepath.Base(flags.file)
+		}
+		if change == defaultCommand {
+			change = "go run " + filepath.Base(flags.file)
+		}
+	}
+
+	return startup, change, nil
+}
+
+// configReloader returns the function through which the monitor re-reads the configuration file
+// before every run following a change, if so requested.  The command is only re-read if it came
+// from the configuration file in the first place, while rules always are.
+func configReloader(commandFromConfig bool) func() (string, []Rule, error) {
+	if !flags.reloadConfig {
+		return nil
+	}
+
+	return func() (string, []Rule, error) {
+		cfg, err := LoadConfig(flags.configFile)
+		if err != nil {
+			return "", nil, err
+		}
+
+		_, change, err := configCommands(cfg)
+		if err != nil {
+			return "", nil, err
+		} else if !commandFromConfig {
+			change = ""
+		}
+		return change, cfg.Rules, nil
+	}
+}
+
+// runTeardown runs the teardown command in the given directory once godepmon shuts down, including
+// at the user's request, in which case the context of the run is already cancelled.  The command is
+// given at most teardownTimeout to complete so that it cannot hold up shutdown indefinitely.
+func runTeardown(dir string, command string) {
+	ctx, cancel := context.WithTimeout(context.Background(), teardownTimeout)
+	defer cancel()
+
+	if err := runHook(ctx, "teardown", dir, command); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Warn().Msgf("teardown did not complete within %s", teardownTimeout)
+		}
+		Error(err.Error())
+		return
+	}
+	log.Info().Msg("teardown completed")
+}
+
+// pollInterval returns the interval at which to scan the given path for changes, or zero to rely on
+// file system events.  Polling is enabled by --poll, or by the GODEPMON_POLL environment variable,
+// and otherwise automatically if the path resides on a file system known not to deliver events,
+// unless the environment variable disables it.
+func pollInterval(path string) time.Duration {
+	if flags.poll {
+		return defaultPollInterval
+	}
+
+	if value := os.Getenv(pollEnv); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			Fatal("Invalid value of %s: %s", pollEnv, value)
+		} else if enabled {
+			return defaultPollInterval
+		}
+		return 0
+	}
+
+	if fs, ok := unreliableFilesystem(path); ok {
+		log.Warn().Msgf("%s file system may not deliver file system events, polling for changes "+
+			"instead (set %s=0 to disable)", fs, pollEnv)
+		return defaultPollInterval
+	}
+	return 0
+}
+
+// changedRecently reports whether any of the files watched for the given path was modified within
+// the given duration.  The outcome is reported, since it determines whether the command runs.  An
+// error is returned if the files watched cannot be determined.
+func changedRecently(path string, since time.Duration) (bool, error) {
+	deps, err := newDepWalker().List(path)
+	if err != nil {
+		return false, &WatcherDepWalkerError{Err: err}
+	}
+
+	if p, ok := modifiedSince(deps, time.Now().Add(-since)); ok {
+		log.Info().Msgf("file modified within the last %s: %s", since, p)
+		return true, nil
+	}
+
+	Notice("No watched file was modified within the last %s, skipping initial run", since)
+	return false, nil
+}
+
+// printSummary prints a summary of the outcome of the runs reported by the given function.
+func printSummary(stats func() RunStats) {
+	s := stats()
+
+	last := "no run has completed"
+	if s.LastTerminated {
+		last = "last run was terminated"
+	} else if s.Passed+s.Failed > 0 && s.LastExitCode == 0 {
+		last = "last run passed"
+	} else if s.Passed+s.Failed > 0 {
+		last = fmt.Sprintf("last run failed with exit code %d", s.LastExitCode)
+	}
+
+	Notice("Summary: %d runs, %d passed, %d failed, %d terminated; %s",
+		s.Runs, s.Passed, s.Failed, s.Terminated, last)
+}
+
+// shutdownContext returns a context that is cancelled when one of the shutdown signals is received,
+// along with a channel receiving the default shutdown signals that are not configured.  These would
+// otherwise terminate godepmon without terminating the command, and are caught instead so that they
+// can be forwarded to the command with forwardSignals.  They are not ignored with signal.Ignore, as
+// ignored signals remain ignored in the commands godepmon starts, whereas caught ones are reset to
+// their default action.
+func shutdownContext() (context.Context, context.CancelFunc, <-chan os.Signal) {
+	signals, err := ParseSignals(flags.shutdownSignals)
+	if err != nil {
+		Fatal(err.Error())
+	}
+
+	defaults, _ := ParseSignals(defaultShutdownSignals)
+	forwarded := make(chan os.Signal, 1)
+	for _, sig := range defaults {
+		if !slices.Contains(signals, sig) {
+			signal.Notify(forwarded, sig)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), signals...)
+	return ctx, stop, forwarded
+}
+
+// forwardSignals sends the signals received on the channel to the process group of the command
+// until the context is cancelled.  Signals received while the command is not running are discarded.
+func forwardSignals(ctx context.Context, signals <-chan os.Signal, runner *commander) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-signals:
+				if err := runner.Signal(sig.(syscall.Signal)); err != nil {
+					log.Debug().Msgf("not forwarding %s: %v", sig, err)
+				}
+			}
+		}
 	}()
+}
 
-	for {
-		runOnce(path, runner)
+// newConsoleWriter returns the human-readable log writer, with or without timestamps.
+func newConsoleWriter(timestamps bool) zerolog.ConsoleWriter {
+	w := zerolog.ConsoleWriter{
+		Out:     os.Stdout,
+		NoColor: false,
+	}
+	if !timestamps {
+		w.FormatTimestamp = func(i interface{}) string { return "" }
 	}
+	return w
 }
 
-// runOnce performs a single cycle of monitoring and command execution.  It starts the monitoring
-// process, waits for changes, and then executes the specified command.
-func runOnce(path string, runner *commander) {
-	watcher := NewWatcher()
-	go watcher.Watch(path)
-	defer watcher.Close()
+// newDepWalker creates a dependency walker configured according to the command line flags.
+func newDepWalker() *depWalker {
+	return newDepWalkerWithExternal(flags.includeExternalDeps)
+}
 
-	if err := runner.Start(); err != nil {
+// newDepWalkerWithExternal creates a dependency walker configured according to the command line
+// flags, except for whether external dependencies are included, which is given explicitly.
+func newDepWalkerWithExternal(includeExternalDeps bool) *depWalker {
+	if err := ValidateModMode(flags.modMode); err != nil {
 		Fatal(err.Error())
 	}
 
-	err := <-watcher.Wait()
-	log.Debug().Msg("terminating program")
-	if terr := runner.Terminate(); terr != nil {
-		Error(terr.Error())
+	maxDepth := 0
+	if flags.noRecursiveDeps {
+		maxDepth = 1
+	}
+
+	return NewDepWalker(includeExternalDeps,
+		WithTests(flags.includeTests),
+		WithPattern(flags.pattern),
+		WithPatterns(flags.pkgs),
+		WithExtensions(flags.watchExtensions),
+		WithTestdata(flags.watchTestdata),
+		WithIgnoreDirs(flags.ignoreDirs),
+		WithModMode(flags.modMode),
+		WithMaxDepth(maxDepth),
+		WithNestedModules(flags.nestedModules),
+		WithSingleFile(flags.file != ""),
+		WithFast(flags.fast),
+		WithWatchedImports(flags.watchModCache),
+		WithAllPackages(flags.watchAllPackages),
+		WithModuleSearchLimit(flags.moduleSearchLimit),
+		WithExcludeGenerated(flags.excludeGenerated))
+}
+
+// watcherOptions returns the options of the watchers created for the given watch path according to
+// the command line flags, ignoring the changes to the paths for which ignore, if given, reports true.
+func watcherOptions(watchPath string, ignore func(string) bool) []watcherOption {
+	return []watcherOption{
+		WithDepWalker(newDepWalker()),
+		WithManifest(flags.manifest),
+		WithExtraFiles(extraFiles()),
+		WithResolveInterval(flags.resolveInterval),
+		WithPolling(pollInterval(watchPath)),
+		WithIgnore(ignore),
+		WithEventBuffer(flags.eventBuffer),
+		WithGoFilesOnly(flags.goFilesOnly),
+		WithGitHead(flags.watchGitHead),
+		WithContentHash(flags.contentHash),
+		WithSaveOnly(flags.rerunOnSaveOnly),
+		WithIgnoreRemoves(flags.ignoreRemoves),
+		WithDelay(flags.debounce),
+		WithPerFileDebounce(flags.debouncePerFile),
+		WithWarmup(flags.warmup),
+		WithFollowSymlinks(flags.followSymlinks),
+	}
+}
+
+// newEventEmitter creates an event emitter configured according to the command line flags.
+func newEventEmitter() *eventEmitter {
+	if flags.jsonEvents {
+		return NewEventEmitter(os.Stderr)
 	}
+	return NewEventEmitter(nil)
+}
+
+// commanderOptions returns the commander options that correspond to the command line flags, for a
+// command run in the given path.
+func commanderOptions(cmd *cobra.Command, path string) []commanderOption {
+	options := []commanderOption{}
+
+	if cmd.Flags().Changed("command-stdin") {
+		content, err := readCommandStdin(flags.commandStdin)
+		if err != nil {
+			Fatal("Unable to read standard input content for command\n%v", err)
+		}
+		options = append(options, WithStdin(content))
+	}
+	if flags.pipe != "" {
+		options = append(options, WithPipe(flags.pipe))
+	}
+	if flags.pty {
+		options = append(options, WithPTY(true))
+	}
+	if flags.tailOnFail > 0 {
+		options = append(options, WithTailOnFail(flags.tailOnFail))
+	}
+	if flags.changedFile {
+		options = append(options, WithChangedFile(true))
+	}
+	if flags.envFile != "" {
+		options = append(options, WithEnvFile(envFilePath()))
+	}
+	if limits := resourceLimitsFromFlags(); !limits.isZero() {
+		options = append(options, WithLimits(limits))
+	}
+	if flags.shell {
+		options = append(options, WithShell(commandShell()))
+	} else if cmd.Flags().Changed("command-shell-path") {
+		Fatal("--command-shell-path requires --shell")
+	}
+	if flags.commandArgsFile != "" {
+		path, err := filepath.Abs(flags.commandArgsFile)
+		if err != nil {
+			Fatal("Unable to determine path of command arguments file\n%v", err)
+		}
+		options = append(options, WithArgsFile(path))
+	}
+	if flags.logOriginalCommand {
+		options = append(options, WithLogOriginal(true))
+	}
+	if len(flags.outputGlobs) > 0 {
+		if err := ValidateOutputGlobs(flags.outputGlobs); err != nil {
+			Fatal(err.Error())
+		}
+		options = append(options, WithOutputs(flags.outputGlobs))
+	}
+	if flags.cleanRun {
+		// The whole module is copied so that the command can be built in the copy.
+		root := path
+		if gomod, err := NewGoMod(path, flags.moduleSearchLimit); err == nil {
+			root = filepath.Dir(gomod.Path())
+		}
+		options = append(options, WithCleanRun(root))
+	}
+	if flags.killSequence != "" {
+		steps, err := ParseKillSequence(flags.killSequence)
+		if err != nil {
+			Fatal(err.Error())
+		}
+		options = append(options, WithKillSequence(steps))
+	}
+
+	return options
+}
+
+// readCommandStdin returns the content designated by the value of the --command-stdin flag, which
+// is either given literally or, if prefixed with '@', read from the named file.
+func readCommandStdin(value string) (string, error) {
+	name, ok := strings.CutPrefix(value, "@")
+	if !ok {
+		return value, nil
+	}
+
+	content, err := os.ReadFile(name)
 	if err != nil {
-		Fatal(err.Error())
+		return "", err
 	}
+
+	return string(content), nil
+}
+
+// moduleRoot returns the root directory of the module containing path.
+func moduleRoot(path string) string {
+	gomod, err := NewGoMod(path, flags.moduleSearchLimit)
+	if err != nil {
+		Fatal("Unable to determine module root of %s\n%v", path, err)
+	}
+
+	return filepath.Dir(gomod.Path())
+}
+
+// logConfig logs a one-time summary of the effective configuration, so that the settings in use are
+// apparent from the output alone.  The number of files watched is logged once dependencies have
+// been resolved.
+func logConfig(path string, watchPath string, command string) {
+	log.Info().
+		Str("path", path).
+		Str("watch-path", watchPath).
+		Str("command", command).
+		Stringer("debounce", flags.debounce).
+		Bool("include-external-deps", flags.includeExternalDeps).
+		Bool("include-tests", flags.includeTests).
+		Bool("full-reload", flags.fullReload).
+		Bool("follow-symlinks", flags.followSymlinks).
+		Stringer("restart-jitter", flags.restartJitter).
+		Msg("starting godepmon")
+}
+
+// printConfig prints the effective configuration as a JSON object holding the resolved path, watch
+// path and command, along with the values of all flags once defaults have been derived.
+func printConfig(cmd *cobra.Command, path string, watchPath string, command string) {
+	values := map[string]interface{}{}
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Name == "help" || f.Name == "print-config" {
+			return
+		}
+
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			values[f.Name] = sv.GetSlice()
+			return
+		}
+
+		value := f.Value.String()
+		switch f.Value.Type() {
+		case "bool":
+			b, _ := strconv.ParseBool(value)
+			values[f.Name] = b
+		case "int", "count":
+			n, _ := strconv.Atoi(value)
+			values[f.Name] = n
+		default:
+			values[f.Name] = value
+		}
+	})
+
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"path":       path,
+		"watch-path": watchPath,
+		"command":    command,
+		"debounce":   flags.debounce.String(),
+		"flags":      values,
+	}, "", "  ")
+	if err != nil {
+		Fatal("Unable to encode configuration\n%v", err)
+	}
+
+	fmt.Println(string(data))
 }
 
 // processArgs processes the command line arguments to determine the path to monitor and the command
-// to execute. It handles default values and argument parsing logic.
-func processArgs(args []string) (string, string) {
+// to execute. It handles default values and argument parsing logic, falling back to the given
+// command when no command is specified or the command is blank.
+func processArgs(args []string, fallback string) (string, string) {
 	// Attempt to find index of "--" arg
 	sepidx := -1
 	for i, arg := range args {
@@ -152,7 +1119,7 @@ func processArgs(args []string) (string, string) {
 			Fatal("Unable to obtain current directory\n%v", err)
 		}
 
-		return cwd, command
+		return cwd, fallback
 	}
 
 	for i, s := range args {
@@ -160,11 +1127,10 @@ func processArgs(args []string) (string, string) {
 	}
 
 	path = args[0]
-	if len(args) > 1 {
-		parts := args[1:]
-		command = strings.Join(parts, " ")
+	if !isBlankCommand(args[1:]) {
+		command = joinCommand(args[1:])
 	} else {
-		command = defaultCommand
+		command = fallback
 	}
 
 	if stat, err := os.Stat(path); os.IsNotExist(err) {
@@ -175,3 +1141,36 @@ func processArgs(args []string) (string, string) {
 
 	return path, command
 }
+
+// processFileArgs processes the command line arguments in single-file mode, where all arguments make
+// up the command since the file given by the --file flag takes the place of the path.  The returned
+// path is the directory of the file, and the pattern is set to the name of the file.
+func processFileArgs(args []string) (string, string) {
+	if stat, err := os.Stat(flags.file); err != nil {
+		Fatal("Unable to access file: %s\n%v", flags.file, err)
+	} else if stat.IsDir() || filepath.Ext(flags.file) != ".go" {
+		Fatal("Not a Go file: %s", flags.file)
+	}
+	flags.pattern = filepath.Base(flags.file)
+
+	if i := slices.Index(args, "--"); i >= 0 {
+		args = slices.Delete(slices.Clone(args), i, i+1)
+	}
+	if isBlankCommand(args) {
+		return filepath.Dir(flags.file), ""
+	}
+
+	return filepath.Dir(flags.file), joinCommand(args)
+}
+
+// isBlankCommand reports whether the given command arguments are missing or all empty once trimmed
+// of whitespace, as when COMMAND is given as an empty string, in which case the default command is
+// run instead.
+func isBlankCommand(args []string) bool {
+	for _, arg := range args {
+		if strings.TrimSpace(arg) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// The test binary stands in for godepmon when started in place of a command to apply
+	// resource limits to it.
+	execLimited()
+
+	os.Exit(m.Run())
+}
+
+func TestProcessArgsBlankCommand(t *testing.T) {
+	dir := t.TempDir()
+	tests := [][]string{
+		{dir},
+		{dir, "--"},
+		{dir, "--", ""},
+		{dir, "--", "  ", "\t"},
+		{dir, ""},
+	}
+
+	for _, args := range tests {
+		path, command := processArgs(append([]string(nil), args...), "")
+		if path != dir {
+			t.Errorf("processArgs(%q) path = %q, want %q", args, path, dir)
+		}
+		if command != "" {
+			t.Errorf("processArgs(%q) command = %q, want none", args, command)
+		}
+	}
+
+	if _, command := processArgs([]string{dir, "--", "go", "test"}, ""); command != "go test" {
+		t.Errorf("processArgs() command = %q, want %q", command, "go test")
+	}
+}
+
+func TestBlankCommandFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		cfg  Config
+		want string
+	}{
+		{"environment", "make run", Config{Command: "go test ./..."}, "make run"},
+		{"configuration", "", Config{Command: "go test ./..."}, "go test ./..."},
+		{"built-in", "", Config{}, defaultCommand},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(defaultCommandEnv, tt.env)
+
+			_, command := processArgs([]string{t.TempDir(), "--", ""}, "")
+			if command != "" {
+				t.Fatalf("processArgs() command = %q, want none", command)
+			}
+
+			startup, change, err := configCommands(&tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if startup != tt.want || change != tt.want {
+				t.Errorf("configCommands() = %q, %q, want %q", startup, change, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxRuntimeExitCode(t *testing.T) {
+	dir := t.TempDir()
+
+	// A run that exits of its own accord before the maximum runtime is reached.
+	c := NewCommander(dir, "sh -c 'exit 3'")
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Exited():
+	case <-time.After(10 * time.Second):
+		t.Fatal("command did not exit")
+	}
+	if got := maxRuntimeExitCode(c.Stats()); got != 3 {
+		t.Errorf("exited run: maxRuntimeExitCode() = %d, want 3", got)
+	}
+
+	// A run still running when the maximum runtime is reached, following the one that exited.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.SetCommand("sleep 10")
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	exited := c.Exited()
+	cancel()
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		t.Fatal("command not terminated")
+	}
+	if got := maxRuntimeExitCode(c.Stats()); got != terminatedExitCode {
+		t.Errorf("terminated run: maxRuntimeExitCode() = %d, want %d", got, terminatedExitCode)
+	}
+}
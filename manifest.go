@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ManifestError represents an error that occurs when the manifest listing the paths to watch cannot
+// be read.
+type ManifestError struct {
+	Path string
+	Err  error
+}
+
+func (e *ManifestError) Error() string {
+	return fmt.Sprintf("Failed to read manifest '%s'\n%v", e.Path, e.Err)
+}
+
+// readManifest reads the paths to watch from the manifest at the given path, one per line.  Empty
+// lines and lines starting with '#' are skipped.  Relative paths are interpreted relative to the
+// directory of the manifest.  Paths that do not exist are logged and skipped.
+func readManifest(path string) (Deps, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &ManifestError{Path: path, Err: err}
+	}
+
+	base, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, &ManifestError{Path: path, Err: err}
+	}
+
+	deps := Deps{}
+	for _, line := range strings.Split(string(data), "\n") {
+		p := strings.TrimSpace(line)
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(base, p)
+		}
+		if _, err := os.Stat(p); err != nil {
+			log.Warn().Msgf("not watching manifest path: %s: %v", p, err)
+			continue
+		}
+		deps = append(deps, filepath.Clean(p))
+	}
+
+	return dedupeSorted(deps), nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// MetricsServerError represents an error that occurs when the metrics server cannot listen on the
+// configured address.
+type MetricsServerError struct {
+	Addr string
+	Err  error
+}
+
+func (e *MetricsServerError) Error() string {
+	return fmt.Sprintf("Failed to serve metrics on '%s'\n%v", e.Addr, e.Err)
+}
+
+// metrics collects figures about the monitoring of the command, such as how often it is rerun, and
+// exposes them in the Prometheus text format.  All methods are no-ops on a nil instance, so that
+// metrics need only be collected when they are served.
+type metrics struct {
+	exitCode      func() int
+	reruns        int
+	buildDuration time.Duration
+	watchedFiles  int
+	lastChange    time.Time
+	mu            sync.Mutex
+}
+
+// NewMetrics creates a new metrics instance.  The exit code of the last run is obtained from the
+// given function whenever metrics are collected.
+func NewMetrics(exitCode func() int) *metrics {
+	return &metrics{exitCode: exitCode, lastChange: time.Now()}
+}
+
+// Rerun records that the command was restarted following a change.
+func (mx *metrics) Rerun() {
+	if mx == nil {
+		return
+	}
+
+	mx.mu.Lock()
+	defer mx.mu.Unlock()
+	mx.reruns++
+}
+
+// Changed records that a change was detected.
+func (mx *metrics) Changed() {
+	if mx == nil {
+		return
+	}
+
+	mx.mu.Lock()
+	defer mx.mu.Unlock()
+	mx.lastChange = time.Now()
+}
+
+// Built records the time taken by the build performed before restarting the command.
+func (mx *metrics) Built(d time.Duration) {
+	if mx == nil {
+		return
+	}
+
+	mx.mu.Lock()
+	defer mx.mu.Unlock()
+	mx.buildDuration = d
+}
+
+// Watching records the number of files currently watched.
+func (mx *metrics) Watching(n int) {
+	if mx == nil {
+		return
+	}
+
+	mx.mu.Lock()
+	defer mx.mu.Unlock()
+	mx.watchedFiles = n
+}
+
+// ServeHTTP writes the current metrics in the Prometheus text exposition format.
+func (mx *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mx.mu.Lock()
+	reruns, buildDuration := mx.reruns, mx.buildDuration
+	watchedFiles, lastChange := mx.watchedFiles, mx.lastChange
+	mx.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetric(w, "godepmon_reruns_total", "counter",
+		"Number of times the command was restarted following a change.", float64(reruns))
+	writeMetric(w, "godepmon_last_build_duration_seconds", "gauge",
+		"Time taken by the last build gate or build comparison run before restarting the command.",
+		buildDuration.Seconds())
+	writeMetric(w, "godepmon_last_exit_code", "gauge",
+		"Exit code of the last run of the command that exited of its own accord.",
+		float64(mx.exitCode()))
+	writeMetric(w, "godepmon_watched_files", "gauge",
+		"Number of files currently watched.", float64(watchedFiles))
+	writeMetric(w, "godepmon_seconds_since_last_change", "gauge",
+		"Time elapsed since the last change was detected, or since godepmon started.",
+		time.Since(lastChange).Seconds())
+}
+
+// writeMetric writes a single metric, along with its help text and type, in the Prometheus text
+// exposition format.
+func writeMetric(w io.Writer, name string, typ string, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n",
+		name, help, name, typ, name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// ServeMetrics serves the given metrics over HTTP at the /metrics path of the given address, in the
+// background.  The returned server is to be closed once metrics are no longer needed.
+func ServeMetrics(addr string, mx *metrics) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, &MetricsServerError{Addr: addr, Err: err}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", mx)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	log.Info().Msgf("serving metrics on http://%s/metrics", ln.Addr())
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Error().Msgf("metrics server stopped: %v", err)
+		}
+	}()
+
+	return srv, nil
+}
@@ -0,0 +1,695 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// initialStartBackoff specifies the delay before the first attempt to restart a command that
+	// failed to start.  The delay doubles with every subsequent attempt.
+	initialStartBackoff = 100 * time.Millisecond
+
+	// defaultBootWindow specifies how soon after it is first started the command must fail for
+	// godepmon to exit along with it, when failing fast on boot.
+	defaultBootWindow = 5 * time.Second
+)
+
+// BootError represents the failure of the command when it exits with a non-zero status within the
+// boot window following its first start.
+type BootError struct {
+	Code int
+}
+
+func (e *BootError) Error() string {
+	return fmt.Sprintf("Program failed on startup with status %d", e.Code)
+}
+
+// CycleError aggregates the errors encountered during a single monitoring cycle.  Errors are grouped
+// by the stage at which they occurred so that the caller can decide whether the cycle may be
+// retried.
+type CycleError struct {
+	// WatchErr is the error that occurred while setting up or running the watcher.
+	WatchErr error
+	// StartErr is the error that occurred while starting the command.
+	StartErr error
+	// TerminateErr is the error that occurred while terminating the command.
+	TerminateErr error
+	// BootErr is the failure of the command on its first start, when failing fast on boot.
+	BootErr error
+}
+
+func (e *CycleError) Error() string {
+	return errors.Join(e.Unwrap()...).Error()
+}
+
+// Unwrap returns the non-nil errors contained in the aggregate error.
+func (e *CycleError) Unwrap() []error {
+	errs := []error{}
+	for _, err := range []error{e.WatchErr, e.StartErr, e.TerminateErr, e.BootErr} {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Recoverable reports whether monitoring may continue after the cycle failed.  Only failures to
+// terminate the command are considered recoverable, since the next cycle starts afresh.
+func (e *CycleError) Recoverable() bool {
+	return e.WatchErr == nil && e.StartErr == nil && e.BootErr == nil
+}
+
+// monitorOption defines a function signature for options that configure a monitor instance.
+type monitorOption func(m *monitor)
+
+// monitor ties a watcher and a commander together, running the command and restarting it whenever
+// the watcher detects a change.
+type monitor struct {
+	path           string
+	runner         *commander
+	watcherOptions []watcherOption
+	fullReload     bool
+	restartJitter  time.Duration
+	startRetries   int
+	beforeHook     string
+	buildGate      string
+	errorHook      string
+	restartIfDiff  bool
+	reloadSignal   syscall.Signal
+	skipFirstRun   bool
+	separator      bool
+	rules          []Rule
+	maxConcurrent  int
+	changeCommand  string
+	loadConfig     func() (string, []Rule, error)
+	bootWindow     time.Duration
+	watchCount     int
+	bootedAt       time.Time
+	trigger        []string
+	buildHash      string
+	events         *eventEmitter
+	metrics        *metrics
+	cycles         int
+	restarting     bool
+	reloading      bool
+}
+
+// NewMonitor creates a new monitor instance that watches the specified path and controls the given
+// commander.
+func NewMonitor(path string, runner *commander, options ...monitorOption) *monitor {
+	m := &monitor{path: path, runner: runner, watchCount: -1}
+
+	for _, setopt := range options {
+		setopt(m)
+	}
+
+	return m
+}
+
+// WithWatcherOptions configures the options passed to NewWatcher whenever the monitor creates a
+// watcher.
+func WithWatcherOptions(options ...watcherOption) monitorOption {
+	return func(m *monitor) {
+		m.watcherOptions = options
+	}
+}
+
+// WithFullReload configures whether a single watcher is kept alive across cycles and its watch set
+// reconciled after each change, rather than being rebuilt from scratch every cycle.
+func WithFullReload(fullReload bool) monitorOption {
+	return func(m *monitor) {
+		m.fullReload = fullReload
+	}
+}
+
+// WithRestartJitter configures the upper bound of a random delay applied before the command is
+// restarted, so that instances sharing resources do not all restart at the same time.
+func WithRestartJitter(jitter time.Duration) monitorOption {
+	return func(m *monitor) {
+		m.restartJitter = jitter
+	}
+}
+
+// WithStartRetries configures the number of times starting the command is retried, with
+// exponential backoff, before the failure is considered fatal.
+func WithStartRetries(retries int) monitorOption {
+	return func(m *monitor) {
+		m.startRetries = retries
+	}
+}
+
+// WithBeforeHook configures a command that is run to completion before every start of the command.
+// The command is not started if the hook fails, in which case the monitor waits for the next change.
+func WithBeforeHook(command string) monitorOption {
+	return func(m *monitor) {
+		m.beforeHook = command
+	}
+}
+
+// WithBuildGate configures a command that is run to completion whenever a change is detected, before
+// the running command is terminated.  If the gate command fails, the running command is left
+// untouched and the monitor waits for the next change.  This avoids replacing a working program
+// with one that fails to build.
+func WithBuildGate(command string) monitorOption {
+	return func(m *monitor) {
+		m.buildGate = command
+	}
+}
+
+// WithErrorHook configures a command that is run whenever the command exits of its own accord with
+// a non-zero status.  Failure of the error hook is reported but does not stop monitoring.
+func WithErrorHook(command string) monitorOption {
+	return func(m *monitor) {
+		m.errorHook = command
+	}
+}
+
+// WithRestartIfChanged configures whether the command is only restarted when the compiled program
+// changes, as determined by hashing a build of the command's package made on every change.  The
+// command is not restarted either if the build fails.
+func WithRestartIfChanged(restartIfChanged bool) monitorOption {
+	return func(m *monitor) {
+		m.restartIfDiff = restartIfChanged
+	}
+}
+
+// WithReloadSignal configures a signal that is sent to the process group of the running command
+// whenever a change is detected, instead of terminating and restarting it, so that the command can
+// reload itself.  The command is restarted as usual if it is not running.  A zero signal disables
+// reloading.
+func WithReloadSignal(sig syscall.Signal) monitorOption {
+	return func(m *monitor) {
+		m.reloadSignal = sig
+	}
+}
+
+// WithSkipFirstRun configures whether the command is only started once a change is detected, rather
+// than as soon as monitoring begins.
+func WithSkipFirstRun(skip bool) monitorOption {
+	return func(m *monitor) {
+		m.skipFirstRun = skip
+	}
+}
+
+// WithSeparator configures whether a divider line, stating what triggered the run, is printed to
+// standard output before every start of the command so that the output of successive runs is easy
+// to tell apart.  A separator event is emitted instead if events are written as JSON.
+func WithSeparator(separator bool) monitorOption {
+	return func(m *monitor) {
+		m.separator = separator
+	}
+}
+
+// WithRules configures rules whose commands are run, once each, whenever a change affects files
+// matching their globs.  Rules run after the build gate, if any, and before the command is
+// restarted.  Failure of a rule's command is reported but does not prevent the restart.
+func WithRules(rules []Rule) monitorOption {
+	return func(m *monitor) {
+		m.rules = rules
+	}
+}
+
+// WithMaxConcurrent configures the maximum number of rule commands run simultaneously.  Rules
+// matched beyond that number wait for a running command to complete.  Zero removes the limit.
+func WithMaxConcurrent(n int) monitorOption {
+	return func(m *monitor) {
+		m.maxConcurrent = n
+	}
+}
+
+// WithChangeCommand configures a command that replaces the commander's own from the first run
+// following a change onwards, so that the command run on startup may differ from the one run on
+// every change.  An empty command keeps the commander's.
+func WithChangeCommand(command string) monitorOption {
+	return func(m *monitor) {
+		m.changeCommand = command
+	}
+}
+
+// WithConfigReload configures a function that re-reads the command and rules from the
+// configuration file, which the monitor calls before every run following a change so that edits to
+// the file take effect without restarting godepmon.  An empty command keeps the commander's.
+// Should the file fail to load, the error is reported and the last good command and rules are kept.
+func WithConfigReload(load func() (string, []Rule, error)) monitorOption {
+	return func(m *monitor) {
+		m.loadConfig = load
+	}
+}
+
+// WithFailFastBoot configures the window following the first start of the command within which its
+// exiting with a non-zero status ends monitoring with a *BootError, so that a command failing on
+// startup is reported at once while one that keeps running, such as a server, is monitored as
+// usual.  Zero disables the check.
+func WithFailFastBoot(window time.Duration) monitorOption {
+	return func(m *monitor) {
+		m.bootWindow = window
+	}
+}
+
+// WithEvents configures the emitter through which lifecycle events are reported.
+func WithEvents(events *eventEmitter) monitorOption {
+	return func(m *monitor) {
+		m.events = events
+	}
+}
+
+// WithMetrics configures the metrics updated as the command is monitored and restarted.
+func WithMetrics(metrics *metrics) monitorOption {
+	return func(m *monitor) {
+		m.metrics = metrics
+	}
+}
+
+// Run executes the monitoring loop until the context is cancelled or an unrecoverable error occurs.
+// The command is terminated before Run returns.  Cancellation of the context is not considered an
+// error.
+func (m *monitor) Run(ctx context.Context) error {
+	defer m.runner.Terminate()
+
+	var persistent *watcher
+	if m.fullReload {
+		persistent = NewWatcher(m.watcherOptions...)
+		defer persistent.Close()
+		if err := persistent.Watch(ctx, m.path); err != nil {
+			return err
+		}
+	}
+
+	if m.restartIfDiff {
+		hash, err := hashBuild(ctx, m.runner.Dir())
+		if err != nil {
+			log.Warn().Msgf("unable to determine initial build output: %v", err)
+		}
+		m.buildHash = hash
+	}
+
+	for {
+		err := m.runOnce(ctx, persistent)
+		if ctx.Err() != nil {
+			log.Info().Msg("monitoring cancelled, terminating...")
+			return nil
+		} else if err == nil {
+			continue
+		}
+
+		var cerr *CycleError
+		if errors.As(err, &cerr) && cerr.Recoverable() {
+			Error(err.Error())
+			continue
+		}
+
+		return err
+	}
+}
+
+// runOnce performs a single cycle of monitoring and command execution.  It starts the monitoring
+// process, executes the specified command and then waits for changes or for the context to be
+// cancelled.  If a running watcher is given, it is reused and refreshed after the change instead of
+// being created for the cycle.  Any errors encountered are returned as a *CycleError.
+func (m *monitor) runOnce(ctx context.Context, persistent *watcher) error {
+	watcher := persistent
+	if watcher == nil {
+		options := append(m.watcherOptions[:len(m.watcherOptions):len(m.watcherOptions)],
+			WithPreviousCount(m.watchCount))
+		watcher = NewWatcher(options...)
+		defer watcher.Close()
+
+		if err := watcher.Watch(ctx, m.path); err != nil {
+			return &CycleError{WatchErr: err}
+		}
+	}
+	m.watchCount = watcher.Count()
+
+	m.metrics.Watching(watcher.Count())
+
+	if m.cycles > 0 && !m.reloading {
+		m.delayRestart(ctx)
+	}
+	m.cycles++
+	if m.cycles == 2 && m.changeCommand != "" {
+		m.runner.SetCommand(m.changeCommand)
+	}
+	if m.cycles > 1 && m.loadConfig != nil {
+		m.reloadConfig()
+	}
+	m.runner.SetChanges(m.trigger)
+
+	// Exited is only consulted once the command has started in this cycle, or was kept running
+	// across the previous change by reloading it, since it otherwise refers to an earlier command.
+	var exited <-chan struct{}
+	if m.reloading {
+		m.reloading = false
+		exited = m.runner.Exited()
+	} else if m.skipFirstRun && m.cycles == 1 {
+		log.Info().Msg("not starting program: waiting for changes")
+	} else if err := m.runBeforeHook(ctx); err != nil {
+		Error(err.Error())
+		log.Warn().Msg("not starting program: waiting for changes")
+	} else if err := m.start(ctx); err != nil {
+		return &CycleError{StartErr: err}
+	} else {
+		exited = m.runner.Exited()
+		if m.cycles == 1 {
+			m.bootedAt = time.Now()
+		}
+		if m.restarting {
+			m.restarting = false
+			m.metrics.Rerun()
+			m.events.Emit(eventRestartEnd, map[string]interface{}{"cycle": m.cycles})
+		}
+	}
+
+	var err error
+	for {
+		select {
+		case err = <-watcher.Wait():
+		case <-exited:
+			exited = nil
+			if berr := m.bootFailure(ctx); berr != nil {
+				m.runErrorHook(ctx)
+				return &CycleError{BootErr: berr}
+			}
+			m.reportExit(ctx)
+			m.runErrorHook(ctx)
+			continue
+		case <-ctx.Done():
+		}
+
+		if err != nil || ctx.Err() != nil {
+			break
+		}
+
+		m.metrics.Changed()
+		if m.mayRestart(ctx) {
+			break
+		}
+	}
+
+	if err == nil && ctx.Err() == nil {
+		m.trigger = watcher.Changes()
+		m.runRules(ctx)
+	}
+
+	if err == nil && ctx.Err() == nil && m.reload() {
+		m.reloading = true
+		if persistent != nil {
+			if err := persistent.Refresh(); err != nil {
+				return &CycleError{WatchErr: err}
+			}
+		}
+		return nil
+	}
+
+	if err == nil && ctx.Err() == nil {
+		m.restarting = true
+		m.events.Emit(eventRestartBegin, map[string]interface{}{"cycle": m.cycles})
+	}
+
+	log.Debug().Msg("terminating program")
+	terr := m.runner.Terminate()
+	if err == nil && persistent != nil && ctx.Err() == nil {
+		err = persistent.Refresh()
+	}
+	if err != nil || terr != nil {
+		return &CycleError{WatchErr: err, TerminateErr: terr}
+	}
+
+	return nil
+}
+
+// reload sends the reload signal to the running command, if one is configured, and reports whether
+// the command was signalled.  If it was not, the command is to be restarted instead.
+func (m *monitor) reload() bool {
+	if m.reloadSignal == 0 {
+		return false
+	}
+
+	if err := m.runner.Signal(m.reloadSignal); err != nil {
+		var nerr *NotRunningError
+		if !errors.As(err, &nerr) {
+			Error(err.Error())
+		}
+		log.Info().Msg("unable to reload program, restarting it")
+		return false
+	}
+
+	m.events.Emit(eventReload, map[string]interface{}{"cycle": m.cycles})
+	return true
+}
+
+// runBeforeHook runs the before hook, if one is configured.
+func (m *monitor) runBeforeHook(ctx context.Context) error {
+	if m.beforeHook == "" {
+		return nil
+	}
+
+	return runHook(ctx, "before", m.runner.Dir(), m.beforeHook)
+}
+
+// bootFailure returns a *BootError if failing fast on boot and the command, started for the first
+// time, exited with a non-zero status within the boot window.
+func (m *monitor) bootFailure(ctx context.Context) error {
+	if m.bootWindow <= 0 || m.cycles != 1 || m.bootedAt.IsZero() || ctx.Err() != nil {
+		return nil
+	}
+
+	if code := m.runner.ExitCode(); code != 0 && time.Since(m.bootedAt) < m.bootWindow {
+		return &BootError{Code: code}
+	}
+	return nil
+}
+
+// reportExit reports that the command exited of its own accord, along with its exit status,
+// regardless of the logging level.  This makes the completion of short-lived commands apparent, as
+// the monitor otherwise sits idle until the next change.
+func (m *monitor) reportExit(ctx context.Context) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	if code := m.runner.ExitCode(); code != 0 {
+		Notice("Program exited with status %d, waiting for changes", code)
+	} else {
+		Notice("Program completed successfully, waiting for changes")
+	}
+}
+
+// runRules runs the command of every rule matching any of the files affected by the last change.
+// Commands are started in the order the rules are defined, no more than the configured maximum at a
+// time, and all of them are waited for.
+func (m *monitor) runRules(ctx context.Context) {
+	if len(m.rules) == 0 {
+		return
+	}
+
+	root, err := filepath.Abs(m.path)
+	if err != nil {
+		root = m.path
+	}
+
+	slots := m.maxConcurrent
+	if slots <= 0 {
+		slots = len(m.rules)
+	}
+	sem := make(chan struct{}, slots)
+
+	var wg sync.WaitGroup
+	for _, r := range m.rules {
+		for _, p := range m.trigger {
+			rel, err := filepath.Rel(root, p)
+			if err != nil || !r.Matches(rel) {
+				continue
+			}
+
+			log.Debug().Msgf("rule '%s' matched: %s", r.Glob, p)
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(r Rule) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				name := fmt.Sprintf("rule '%s'", r.Glob)
+				if err := runHook(ctx, name, m.runner.Dir(), r.Command); err != nil && ctx.Err() == nil {
+					Error(err.Error())
+				}
+			}(r)
+			break
+		}
+	}
+	wg.Wait()
+}
+
+// runErrorHook runs the error hook, if one is configured, provided the command exited with a
+// non-zero status.  Failure of the hook is reported but otherwise ignored.
+func (m *monitor) runErrorHook(ctx context.Context) {
+	code := m.runner.ExitCode()
+	if m.errorHook == "" || code == 0 || ctx.Err() != nil {
+		return
+	}
+
+	if err := runHook(ctx, "error", m.runner.Dir(), m.errorHook); err != nil && ctx.Err() == nil {
+		Error(err.Error())
+	}
+}
+
+// mayRestart runs the build gate and compares build outputs, as configured, and reports whether the
+// command may be restarted.  The time taken by these builds is recorded in the metrics.
+func (m *monitor) mayRestart(ctx context.Context) bool {
+	if m.buildGate == "" && !m.restartIfDiff {
+		return true
+	}
+
+	start := time.Now()
+	defer func() { m.metrics.Built(time.Since(start)) }()
+
+	return m.passBuildGate(ctx) && m.buildChanged(ctx)
+}
+
+// passBuildGate runs the build gate, if one is configured, and reports whether the command may be
+// restarted.
+func (m *monitor) passBuildGate(ctx context.Context) bool {
+	if m.buildGate == "" {
+		return true
+	}
+
+	if err := runHook(ctx, "build gate", m.runner.Dir(), m.buildGate); err != nil {
+		if ctx.Err() == nil {
+			Error(err.Error())
+			log.Warn().Msg("not restarting program: build gate failed, waiting for changes")
+		}
+		return false
+	}
+
+	return true
+}
+
+// buildChanged reports whether the command may be restarted because the output of building its
+// package differs from that of the running command.  It always does unless WithRestartIfChanged was
+// given.
+func (m *monitor) buildChanged(ctx context.Context) bool {
+	if !m.restartIfDiff {
+		return true
+	}
+
+	hash, err := hashBuild(ctx, m.runner.Dir())
+	if err != nil {
+		if ctx.Err() == nil {
+			Error(err.Error())
+			log.Warn().Msg("not restarting program: build failed, waiting for changes")
+		}
+		return false
+	} else if hash == m.buildHash {
+		log.Info().Msg("not restarting program: build output unchanged, waiting for changes")
+		return false
+	}
+
+	m.buildHash = hash
+	return true
+}
+
+// printSeparator prints the divider line preceding a start of the command, if enabled, stating
+// which file changed to trigger the run.
+func (m *monitor) printSeparator() {
+	if !m.separator {
+		return
+	}
+
+	reason := "initial run"
+	if len(m.trigger) > 0 {
+		reason = m.trigger[0]
+		if root, err := filepath.Abs(m.path); err == nil {
+			if rel, err := filepath.Rel(root, reason); err == nil && !strings.HasPrefix(rel, "..") {
+				reason = rel
+			}
+		}
+		if len(m.trigger) > 1 {
+			reason += fmt.Sprintf(" and %d more", len(m.trigger)-1)
+		}
+	}
+
+	if m.events.Structured() {
+		m.events.Emit(eventSeparator, map[string]interface{}{"cycle": m.cycles, "reason": reason})
+		return
+	}
+
+	rule := strings.Repeat("─", 10)
+	fmt.Printf("%s run @ %s (%s) %s\n", rule, time.Now().Format("15:04:05"), reason, rule)
+}
+
+// start starts the command, retrying with exponential backoff if it fails to start and retries have
+// been configured.  Only failures to start the command process are retried.
+func (m *monitor) start(ctx context.Context) error {
+	m.printSeparator()
+
+	backoff := initialStartBackoff
+	for attempt := 0; ; attempt++ {
+		err := m.runner.Start(ctx)
+
+		var serr *StartCommandError
+		if err == nil || attempt >= m.startRetries || !errors.As(err, &serr) {
+			return err
+		}
+
+		log.Warn().Msgf("failed to start program, retrying in %s (attempt %d of %d): %v",
+			backoff, attempt+1, m.startRetries, serr.Err)
+		if !sleep(ctx, backoff) {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// reloadConfig re-reads the command and rules from the configuration file, keeping the current
+// ones should the file be invalid.
+func (m *monitor) reloadConfig() {
+	command, rules, err := m.loadConfig()
+	if err != nil {
+		Error(err.Error())
+		log.Warn().Msg("not reloading configuration: keeping last good command and rules")
+		return
+	}
+
+	if command != "" && command != m.runner.Command() {
+		log.Info().Msgf("command changed in configuration: %s", command)
+		m.runner.SetCommand(command)
+	}
+	m.rules = rules
+}
+
+// delayRestart sleeps for a random duration bounded by the restart jitter, returning early if the
+// context is cancelled.
+func (m *monitor) delayRestart(ctx context.Context) {
+	if m.restartJitter <= 0 {
+		return
+	}
+
+	delay := time.Duration(rand.Int63n(int64(m.restartJitter)))
+	log.Debug().Msgf("delaying restart by %s", delay)
+	sleep(ctx, delay)
+}
+
+// sleep pauses for the given duration or until the context is cancelled.  It returns false if the
+// context was cancelled.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
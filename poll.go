@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultPollInterval specifies the interval at which watched directories are scanned for
+	// changes when polling.
+	defaultPollInterval = 500 * time.Millisecond
+
+	// pollEnv names the environment variable that, when set to a true value, forces polling and,
+	// when set to a false value, prevents polling from being enabled automatically.
+	pollEnv = "GODEPMON_POLL"
+)
+
+// fsWatcher is a source of file system events for the paths added to it, implemented on top of
+// fsnotify or by polling.  Events and errors are delivered on channels that are closed once the
+// source is closed.
+type fsWatcher interface {
+	Add(name string) error
+	Remove(name string) error
+	Close() error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+}
+
+// notifyWatcher adapts an fsnotify watcher to the fsWatcher interface.
+type notifyWatcher struct {
+	*fsnotify.Watcher
+}
+
+// Events returns the channel on which the fsnotify watcher delivers events.
+func (n notifyWatcher) Events() <-chan fsnotify.Event {
+	return n.Watcher.Events
+}
+
+// Errors returns the channel on which the fsnotify watcher delivers errors.
+func (n notifyWatcher) Errors() <-chan error {
+	return n.Watcher.Errors
+}
+
+// fileState holds the attributes of a file compared between scans to detect changes.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	mode    fs.FileMode
+}
+
+// pollWatcher detects changes by scanning the paths added to it at a regular interval, for file
+// systems that do not deliver file system events reliably, such as those shared with a container
+// or virtual machine.  Directories are scanned one level deep, as with fsnotify.  Changes to a
+// file's modification time or size are reported as writes, and changes to its mode alone as
+// attribute changes.
+type pollWatcher struct {
+	interval  time.Duration
+	roots     map[string]map[string]fileState
+	events    chan fsnotify.Event
+	errors    chan error
+	stop      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+}
+
+// newPollWatcher creates a polling watcher scanning at the given interval and starts scanning.
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	p := &pollWatcher{
+		interval: interval,
+		roots:    make(map[string]map[string]fileState),
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		stop:     make(chan struct{}),
+	}
+
+	go p.run()
+	return p
+}
+
+// Add starts watching the given path, which is either a directory or a file.
+func (p *pollWatcher) Add(name string) error {
+	snap, err := snapshot(name)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.roots[name] = snap
+	return nil
+}
+
+// Remove stops watching the given path.  An error is returned if the path is not watched.
+func (p *pollWatcher) Remove(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.roots[name]; !ok {
+		return fmt.Errorf("path not watched: %s", name)
+	}
+	delete(p.roots, name)
+	return nil
+}
+
+// Close stops scanning, after which the event and error channels are closed.
+func (p *pollWatcher) Close() error {
+	p.closeOnce.Do(func() { close(p.stop) })
+	return nil
+}
+
+// Events returns the channel on which changes are delivered.
+func (p *pollWatcher) Events() <-chan fsnotify.Event {
+	return p.events
+}
+
+// Errors returns the channel on which errors encountered while scanning are delivered.
+func (p *pollWatcher) Errors() <-chan error {
+	return p.errors
+}
+
+// run scans the watched paths at every tick of the interval until the watcher is closed.
+func (p *pollWatcher) run() {
+	defer close(p.errors)
+	defer close(p.events)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if !p.scan() {
+			return
+		}
+	}
+}
+
+// scan compares every watched path with its last snapshot and delivers the differences as events.
+// It reports false if the watcher was closed meanwhile.
+func (p *pollWatcher) scan() bool {
+	p.mu.Lock()
+	roots := sortedKeys(setOf(p.roots))
+	p.mu.Unlock()
+
+	for _, root := range roots {
+		snap, err := snapshot(root)
+		if errors.Is(err, fs.ErrNotExist) {
+			// A watched path that is removed is no longer watched, as with fsnotify.
+			p.mu.Lock()
+			prev := p.roots[root]
+			delete(p.roots, root)
+			p.mu.Unlock()
+
+			events := diffSnapshots(prev, nil)
+			if _, ok := prev[root]; !ok {
+				events = append(events, fsnotify.Event{Name: root, Op: fsnotify.Remove})
+			}
+			for _, e := range events {
+				if !p.send(e) {
+					return false
+				}
+			}
+			continue
+		} else if err != nil {
+			select {
+			case p.errors <- err:
+			case <-p.stop:
+				return false
+			}
+			continue
+		}
+
+		p.mu.Lock()
+		prev, ok := p.roots[root]
+		if ok {
+			p.roots[root] = snap
+		}
+		p.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		for _, e := range diffSnapshots(prev, snap) {
+			if !p.send(e) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// send delivers an event, reporting false if the watcher was closed before it could be delivered.
+func (p *pollWatcher) send(e fsnotify.Event) bool {
+	log.Trace().Msgf("polled event: %s", e)
+	select {
+	case p.events <- e:
+		return true
+	case <-p.stop:
+		return false
+	}
+}
+
+// snapshot records the state of the given file or, if it is a directory, of its entries.
+func snapshot(path string) (map[string]fileState, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !stat.IsDir() {
+		return map[string]fileState{path: stateOf(stat)}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := make(map[string]fileState, len(entries))
+	for _, e := range entries {
+		// Entries removed since the directory was read are left out.
+		if info, err := e.Info(); err == nil {
+			snap[filepath.Join(path, e.Name())] = stateOf(info)
+		}
+	}
+	return snap, nil
+}
+
+// stateOf returns the attributes of a file compared between scans.
+func stateOf(info fs.FileInfo) fileState {
+	return fileState{modTime: info.ModTime(), size: info.Size(), mode: info.Mode()}
+}
+
+// diffSnapshots returns the events that turn the previous snapshot into the next one: creations
+// and changes, followed by removals, each sorted by path.
+func diffSnapshots(prev map[string]fileState, next map[string]fileState) []fsnotify.Event {
+	var events []fsnotify.Event
+	for _, name := range sortedKeys(setOf(next)) {
+		old, ok := prev[name]
+		cur := next[name]
+		switch {
+		case !ok:
+			events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Create})
+		case !old.modTime.Equal(cur.modTime) || old.size != cur.size:
+			events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Write})
+		case old.mode != cur.mode:
+			events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Chmod})
+		}
+	}
+	for _, name := range sortedKeys(setOf(prev)) {
+		if _, ok := next[name]; !ok {
+			events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Remove})
+		}
+	}
+
+	return events
+}
+
+// setOf returns the set of keys of the given map.
+func setOf[V any](m map[string]V) map[string]struct{} {
+	set := make(map[string]struct{}, len(m))
+	for k := range m {
+		set[k] = struct{}{}
+	}
+	return set
+}
@@ -0,0 +1,54 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strings"
+	"unsafe"
+)
+
+// argvMemory holds the memory in which the kernel placed the program's arguments, and from which it
+// reads the command line shown by tools such as ps and top.  It is nil if the arguments are not laid
+// out as expected, in which case the process title cannot be set.
+var argvMemory []byte
+
+// init locates the memory holding the program's arguments before they are parsed, and replaces the
+// arguments with copies so that overwriting that memory does not alter them.
+func init() {
+	argvMemory = locateArgv(os.Args)
+	for i, arg := range os.Args {
+		os.Args[i] = strings.Clone(arg)
+	}
+}
+
+// locateArgv returns the memory spanned by the given arguments, provided they are laid out
+// contiguously and separated by NUL bytes, as the kernel does.
+func locateArgv(args []string) []byte {
+	if len(args) == 0 || args[0] == "" {
+		return nil
+	}
+
+	start := uintptr(unsafe.Pointer(unsafe.StringData(args[0])))
+	next := start
+	for _, arg := range args {
+		if arg == "" || uintptr(unsafe.Pointer(unsafe.StringData(arg))) != next {
+			return nil
+		}
+		next += uintptr(len(arg)) + 1
+	}
+
+	return unsafe.Slice(unsafe.StringData(args[0]), next-start-1)
+}
+
+// setProcTitle sets the command line of the process as shown by tools such as ps and top.  The
+// title is truncated to the length of the original command line.  It is best-effort and does
+// nothing if the memory holding the command line could not be located.
+func setProcTitle(title string) {
+	if argvMemory == nil {
+		return
+	}
+
+	n := copy(argvMemory, title)
+	clear(argvMemory[n:])
+}
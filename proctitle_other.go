@@ -0,0 +1,6 @@
+//go:build !linux
+
+package main
+
+// setProcTitle does nothing since setting the process title is only supported on Linux.
+func setProcTitle(title string) {}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// runRepeatedly runs the command the given number of times in succession, waiting for each run to
+// complete before starting the next, and without monitoring for changes.  If failFast is true, it
+// stops at the first run that fails.  It returns the exit code of the last failed run, or 0 if all
+// runs succeeded, along with an error if the command could not be started.
+func runRepeatedly(ctx context.Context, runner *commander, runs int, failFast bool) (int, error) {
+	defer runner.Terminate()
+
+	failures, code := 0, 0
+	for i := 1; i <= runs; i++ {
+		log.Info().Msgf("starting run %d of %d", i, runs)
+		if err := runner.Start(ctx); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return code, err
+		}
+
+		select {
+		case <-runner.Exited():
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			log.Info().Msg("runs cancelled, terminating...")
+			break
+		}
+
+		// Terminating a command that has exited reaps any processes it left behind.
+		if err := runner.Terminate(); err != nil {
+			Error(err.Error())
+		}
+
+		if c := runner.ExitCode(); c != 0 {
+			failures++
+			code = c
+			if failFast {
+				Error("Run %d of %d failed with exit code %d", i, runs, c)
+				return code, nil
+			}
+		}
+	}
+
+	if failures > 0 {
+		Error("%d of %d runs failed", failures, runs)
+	}
+
+	return code, nil
+}
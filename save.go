@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// isEditorTempFile reports whether the given path names one of the transient files editors create
+// while saving or editing a file, whose events say nothing about the file being saved:
+//
+//   - Vim: swap files (.name.swp, .name.swx, ...), backups (name~) and the 4913 file written to
+//     check that the directory is writable;
+//   - Emacs: lock files (.#name), auto-save files (#name#) and backups (name~);
+//   - JetBrains IDEs: the name___jb_tmp___ and name___jb_old___ files of their safe writes;
+//   - GNOME editors: the .goutputstream-* files of their atomic saves;
+//   - Kate: swap files (.name.kate-swp).
+func isEditorTempFile(path string) bool {
+	name := filepath.Base(path)
+	switch {
+	case name == "4913",
+		strings.HasSuffix(name, "~"),
+		strings.HasPrefix(name, ".#"),
+		strings.HasPrefix(name, "#") && strings.HasSuffix(name, "#"),
+		strings.HasSuffix(name, "___jb_tmp___"),
+		strings.HasSuffix(name, "___jb_old___"),
+		strings.HasPrefix(name, ".goutputstream-"),
+		strings.HasSuffix(name, ".kate-swp"),
+		strings.HasPrefix(name, ".") && isVimSwapExt(filepath.Ext(name)):
+		return true
+	}
+	return false
+}
+
+// isVimSwapExt reports whether the given extension is one Vim uses for swap files: .swp, followed
+// by .swo, .swn and so on down to .saa should earlier ones be taken.
+func isVimSwapExt(ext string) bool {
+	return len(ext) == 4 && ext[1] == 's' && ext[2] >= 'a' && ext[2] <= 'w' &&
+		ext[3] >= 'a' && ext[3] <= 'z'
+}
+
+// isSave reports whether the given events, accumulated for a path over a debounce window, amount
+// to the file being saved: the path names a regular file once the window has elapsed, and its
+// content may have changed.  Attribute changes alone are not saves, and neither is a file that is
+// gone, whether it was deleted or was a temporary file renamed over the file being saved.  A file
+// that was renamed or removed and then recreated, as editors saving atomically do, is a save.
+func isSave(path string, op fsnotify.Op) bool {
+	if op&^fsnotify.Chmod == 0 {
+		return false
+	}
+
+	stat, err := os.Stat(path)
+	return err == nil && stat.Mode().IsRegular()
+}
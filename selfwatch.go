@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// reexecDir holds the directory from which godepmon is to be executed anew once the root command
+// returns, following a change to its configuration file.  It is empty if godepmon is to exit.
+var reexecDir string
+
+// watchConfigFile returns a context that is cancelled along with the given one, or once the
+// configuration file at the given path, which need not exist, changes and remains valid.  A change
+// making the file invalid is reported and otherwise ignored, so that monitoring carries on with the
+// configuration in effect.  An empty path stands for the default configuration file, as with
+// LoadConfig.  The returned function reports whether the file changed.
+func watchConfigFile(ctx context.Context, path string) (context.Context, func() bool, error) {
+	file := path
+	if file == "" {
+		file = defaultConfigFile
+	}
+
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, nil, &PathAdditionError{Path: file, Err: err}
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, &WatcherCreationError{Err: err}
+	}
+
+	// The directory is watched, rather than the file, since editors commonly replace the file
+	// when saving it.
+	if err := fsw.Add(filepath.Dir(abs)); err != nil {
+		fsw.Close()
+		return nil, nil, &PathAdditionError{Path: filepath.Dir(abs), Err: err}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	changed := make(chan struct{})
+	go func() {
+		defer fsw.Close()
+		defer cancel()
+
+		var settled <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-fsw.Events:
+				if !ok {
+					return
+				} else if e.Name != abs || e.Op == fsnotify.Chmod {
+					continue
+				}
+				log.Trace().Msgf("configuration file event: %s", e)
+				settled = time.After(defaultDebounceDelay)
+			case err, ok := <-fsw.Errors:
+				if !ok {
+					return
+				}
+				log.Warn().Msgf("error watching configuration file: %v", err)
+			case <-settled:
+				settled = nil
+				if _, err := LoadConfig(path); err != nil {
+					Error(err.Error())
+					log.Warn().Msg("not reloading configuration: invalid")
+					continue
+				}
+
+				Notice("Configuration file changed, restarting godepmon")
+				close(changed)
+				return
+			}
+		}
+	}()
+
+	return ctx, func() bool {
+		select {
+		case <-changed:
+			return true
+		default:
+			return false
+		}
+	}, nil
+}
+
+// reexec replaces the godepmon process with a new instance run from the given directory with the
+// same arguments and environment, so that the new instance reads the configuration anew.
+func reexec(dir string) {
+	exe, err := os.Executable()
+	if err != nil {
+		Fatal("Unable to restart godepmon\n%v", err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		Fatal("Unable to restart godepmon\n%v", err)
+	}
+
+	log.Debug().Msgf("executing %s anew", exe)
+	if err := syscall.Exec(exe, os.Args, os.Environ()); err != nil {
+		Fatal("Unable to restart godepmon\n%v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// signalsByName maps the names of the signals that may be configured to trigger shutdown, without
+// the SIG prefix, to the signals themselves.
+var signalsByName = map[string]os.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// defaultShutdownSignals lists the names of the signals that trigger shutdown by default.
+var defaultShutdownSignals = []string{"INT", "TERM"}
+
+// SignalError represents an error that occurs when an unknown signal name is given.
+type SignalError struct {
+	Name string
+}
+
+func (e *SignalError) Error() string {
+	names := make([]string, 0, len(signalsByName))
+	for name := range signalsByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return fmt.Sprintf("Unknown signal '%s': must be one of %s", e.Name, strings.Join(names, ", "))
+}
+
+// ParseSignals converts signal names into signals.  Names are case-insensitive and may carry the
+// SIG prefix.  An error is returned if any name is unknown.
+func ParseSignals(names []string) ([]os.Signal, error) {
+	signals := make([]os.Signal, 0, len(names))
+	for _, name := range names {
+		sig, ok := signalsByName[strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")]
+		if !ok {
+			return nil, &SignalError{Name: name}
+		}
+		signals = append(signals, sig)
+	}
+
+	return signals, nil
+}
+
+// signalName returns the conventional name of the given signal, such as SIGTERM, or its
+// description if it is not one of the signals godepmon knows by name.
+func signalName(sig syscall.Signal) string {
+	if sig == syscall.SIGKILL {
+		return "SIGKILL"
+	}
+	for name, s := range signalsByName {
+		if s == sig {
+			return "SIG" + name
+		}
+	}
+	return sig.String()
+}
+
+// killStep represents a step of the sequence followed to terminate the command: a signal sent to
+// the process group of the command and how long to wait for the command to exit before escalating
+// to the next step.  A zero timeout stands for the termination timeout of the commander.
+type killStep struct {
+	Signal  syscall.Signal
+	Timeout time.Duration
+}
+
+// KillSequenceError represents an error that occurs when a kill sequence cannot be parsed.
+type KillSequenceError struct {
+	Sequence string
+	Err      error
+}
+
+func (e *KillSequenceError) Error() string {
+	return fmt.Sprintf("Invalid kill sequence '%s'\n%v", e.Sequence, e.Err)
+}
+
+// ParseKillSequence parses a comma-separated sequence of signals, each optionally followed by a
+// colon and the time to wait for the command to exit before escalating, e.g. "SIGTERM:2s,SIGKILL".
+// Signal names are those accepted by ParseSignals, plus KILL, which may only end the sequence.
+func ParseKillSequence(sequence string) ([]killStep, error) {
+	fields := strings.Split(sequence, ",")
+	steps := make([]killStep, 0, len(fields))
+	for i, field := range fields {
+		name, timeout, hasTimeout := strings.Cut(field, ":")
+
+		var step killStep
+		if strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG") == "KILL" {
+			if i != len(fields)-1 {
+				return nil, &KillSequenceError{Sequence: sequence,
+					Err: fmt.Errorf("SIGKILL must be the last signal")}
+			}
+			step.Signal = syscall.SIGKILL
+		} else {
+			signals, err := ParseSignals([]string{name})
+			if err != nil {
+				return nil, &KillSequenceError{Sequence: sequence, Err: err}
+			}
+			step.Signal = signals[0].(syscall.Signal)
+		}
+
+		if hasTimeout {
+			d, err := time.ParseDuration(strings.TrimSpace(timeout))
+			if err != nil {
+				return nil, &KillSequenceError{Sequence: sequence, Err: err}
+			} else if d <= 0 {
+				return nil, &KillSequenceError{Sequence: sequence,
+					Err: fmt.Errorf("timeout of %s must be positive", name)}
+			}
+			step.Timeout = d
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
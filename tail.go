@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+// tailBuffer retains the last lines written to it, up to a maximum, so that the end of the output
+// of a command can be shown again once it has scrolled past.  It is safe for concurrent use, as
+// the standard output and standard error of a command are written to it independently.
+type tailBuffer struct {
+	max     int
+	lines   []string
+	partial []byte
+	mu      sync.Mutex
+}
+
+// newTailBuffer creates a tail buffer retaining at most the given number of lines.
+func newTailBuffer(max int) *tailBuffer {
+	return &tailBuffer{max: max, lines: make([]string, 0, max)}
+}
+
+// Write records the complete lines contained in p, holding back a trailing partial line until it
+// is completed by a subsequent write.  It never fails.
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data := append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		t.add(string(bytes.TrimSuffix(data[:i], []byte("\r"))))
+		data = data[i+1:]
+	}
+	t.partial = append([]byte(nil), data...)
+
+	return len(p), nil
+}
+
+// add appends a line, dropping the oldest line if the buffer is full.
+func (t *tailBuffer) add(line string) {
+	if len(t.lines) == t.max {
+		copy(t.lines, t.lines[1:])
+		t.lines = t.lines[:t.max-1]
+	}
+	t.lines = append(t.lines, line)
+}
+
+// Lines returns the lines retained, oldest first, including any trailing partial line, and reports
+// whether the last line is partial.
+func (t *tailBuffer) Lines() ([]string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := append([]string(nil), t.lines...)
+	if len(t.partial) == 0 {
+		return lines, false
+	}
+
+	lines = append(lines, string(t.partial))
+	if len(lines) > t.max {
+		lines = lines[1:]
+	}
+	return lines, true
+}
+
+// Reset discards all the lines retained.
+func (t *tailBuffer) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lines = t.lines[:0]
+	t.partial = nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/rs/zerolog/log"
+)
+
+// terminal represents a pseudo-terminal to which a command is attached, so that the command behaves
+// as when run from a terminal, e.g. by keeping its output colored.
+type terminal struct {
+	pty     *os.File
+	tty     *os.File
+	relayed chan struct{}
+}
+
+// openTerminal allocates a pseudo-terminal sized after godepmon's own terminal, if any.
+func openTerminal() (*terminal, error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pty.InheritSize(os.Stdin, ptmx); err != nil {
+		log.Trace().Msgf("unable to size pseudo-terminal: %v", err)
+	}
+
+	return &terminal{pty: ptmx, tty: tty, relayed: make(chan struct{})}, nil
+}
+
+// attach connects the standard streams of the command to the terminal and makes the terminal the
+// controlling terminal of the command.  The command is started in a new session, which also places
+// it in a process group of its own.
+func (t *terminal) attach(cmd *exec.Cmd) {
+	cmd.Stdin = t.tty
+	cmd.Stdout = t.tty
+	cmd.Stderr = t.tty
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+}
+
+// relay releases godepmon's end of the command's side of the terminal, which the started command
+// holds, and copies the command's output to w until the terminal is closed.  If given, closer is
+// closed once copying ends.
+func (t *terminal) relay(w io.Writer, closer io.Closer) {
+	t.tty.Close()
+
+	go func() {
+		defer close(t.relayed)
+		if closer != nil {
+			defer closer.Close()
+		}
+
+		// Reading fails with EIO once the command and all processes sharing the terminal have
+		// exited, which is how the end of output is signalled.
+		io.Copy(w, t.pty)
+	}()
+}
+
+// close waits for the command's output to be relayed, for at most the given timeout since processes
+// left behind by the command may hold on to the terminal, and then closes the terminal.
+func (t *terminal) close(timeout time.Duration) {
+	select {
+	case <-t.relayed:
+	case <-time.After(timeout):
+		log.Debug().Msg("pseudo-terminal still in use, closing it")
+	}
+
+	t.pty.Close()
+	<-t.relayed
+}
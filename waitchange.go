@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// noChangeExitCode specifies the status with which godepmon exits when no change is detected
+// before the timeout given to --wait-for-change elapses, as timeout(1) does.
+const noChangeExitCode = 124
+
+// waitForChange watches the files that would be monitored for the given path until a single change
+// is detected, without running any command, and returns the status with which godepmon is to exit:
+// zero if a change was detected, or noChangeExitCode if the timeout, if positive, elapsed first.
+// Cancellation of the context, such as on interrupt, returns one.
+func waitForChange(ctx context.Context, path string, timeout time.Duration) int {
+	watcher := NewWatcher(watcherOptions(path, nil)...)
+	defer watcher.Close()
+	if err := watcher.Watch(ctx, path); err != nil {
+		Fatal(err.Error())
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+
+	select {
+	case err := <-watcher.Wait():
+		if err != nil {
+			Fatal(err.Error())
+		}
+		log.Info().Msgf("change detected: %d files changed", len(watcher.Changes()))
+		return 0
+	case <-expired:
+		log.Info().Msgf("no change detected within %s", timeout)
+		return noChangeExitCode
+	case <-ctx.Done():
+		return 1
+	}
+}
@@ -1,7 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,6 +21,14 @@ const (
 	// defaultDebounceDelay specifies the default delay duration used for debouncing file system
 	// events.
 	defaultDebounceDelay = 250 * time.Millisecond
+
+	// addProgressInterval specifies the number of paths added to the watcher between progress
+	// log messages.
+	addProgressInterval = 500
+
+	// defaultEventBuffer specifies the default number of file system events buffered between
+	// their reading and processing.
+	defaultEventBuffer = 1024
 )
 
 // WatcherAlreadyRunningError indicates an error when starting a watcher that is already running.
@@ -64,18 +80,48 @@ type watcherOption func(w *watcher)
 
 // watcher encapsulates the logic for watching file system events with debounce handling.
 type watcher struct {
-	debounceDelay time.Duration
-	watcher       *fsnotify.Watcher
-	timer         *time.Timer
-	mu            sync.Mutex
-	done          chan error
-	closed        bool
+	debounceDelay  time.Duration
+	perFile        bool
+	warmup         time.Duration
+	followSymlinks bool
+	ignore         func(path string) bool
+	eventBuffer    int
+	goFilesOnly    bool
+	gitHead        bool
+	gitDir         string
+	contentHash    bool
+	saveOnly       bool
+	ignoreRemoves  bool
+	hashes         map[string]string
+	pending        map[string]fsnotify.Op
+	changes        []string
+	walker         *depWalker
+	manifest       string
+	extraFiles     []string
+	previousCount  int
+	resolveEvery   time.Duration
+	clock          clock
+	path           string
+	deps           map[string]struct{}
+	dirs           map[string]struct{}
+	startedAt      time.Time
+	watcher        fsWatcher
+	pollInterval   time.Duration
+	timer          timer
+	fileTimers     map[string]timer
+	mu             sync.Mutex
+	done           chan error
+	closed         bool
 }
 
 // NewWatcher creates a new watcher instance configured with the provided options.
 func NewWatcher(options ...watcherOption) *watcher {
 	w := &watcher{
 		debounceDelay: defaultDebounceDelay,
+		walker:        NewDepWalker(false),
+		clock:         realClock{},
+		previousCount: -1,
+		done:          make(chan error, 1),
 	}
 
 	for _, setopt := range options {
@@ -85,49 +131,372 @@ func NewWatcher(options ...watcherOption) *watcher {
 	return w
 }
 
-// WithDelay configures the debounce delay for a watcher instance.
+// WithDelay configures the debounce delay for a watcher instance.  A delay of zero disables
+// debouncing, so that every event is processed as soon as it is received.
 func WithDelay(delay time.Duration) watcherOption {
 	return func(w *watcher) {
 		w.debounceDelay = delay
 	}
 }
 
-// Watch starts the watcher on the specified path. It returns an error if the watcher is already
-// running or fails to start.
-func (w *watcher) Watch(path string) error {
+// WithPerFileDebounce configures whether events are debounced independently for every file, rather
+// than through a single timer shared by all files.  This prevents a file that changes continuously
+// from indefinitely delaying the processing of changes to other files.
+func WithPerFileDebounce(perFile bool) watcherOption {
+	return func(w *watcher) {
+		w.perFile = perFile
+	}
+}
+
+// WithWarmup configures a period, starting when the watcher begins watching, during which change
+// events are ignored.  This lets tools that touch files right after the command starts settle.
+func WithWarmup(warmup time.Duration) watcherOption {
+	return func(w *watcher) {
+		w.warmup = warmup
+	}
+}
+
+// WithDepWalker configures the dependency walker used to determine the files to be watched.
+func WithDepWalker(walker *depWalker) watcherOption {
+	return func(w *watcher) {
+		w.walker = walker
+	}
+}
+
+// WithFollowSymlinks configures whether symlinked dependencies are resolved to their targets
+// before being added to the watcher.
+func WithFollowSymlinks(follow bool) watcherOption {
+	return func(w *watcher) {
+		w.followSymlinks = follow
+	}
+}
+
+// WithIgnore configures a function that is consulted for every relevant event and causes the event
+// to be ignored if it returns true.
+func WithIgnore(ignore func(path string) bool) watcherOption {
+	return func(w *watcher) {
+		w.ignore = ignore
+	}
+}
+
+// WithEventBuffer configures the size of a buffer of file system events placed between the reading
+// of events from fsnotify and their processing, so that bursts of changes are absorbed rather than
+// overflowing fsnotify's own queue.  A size of zero processes events as they are read.
+func WithEventBuffer(size int) watcherOption {
+	return func(w *watcher) {
+		w.eventBuffer = size
+	}
+}
+
+// WithGoFilesOnly configures whether only events on Go source files that are resolved dependencies
+// are processed, regardless of which other files or directories are being watched.
+func WithGoFilesOnly(goFilesOnly bool) watcherOption {
+	return func(w *watcher) {
+		w.goFilesOnly = goFilesOnly
+	}
+}
+
+// WithGitHead configures whether the HEAD file of the git repository containing the watched path
+// is also watched, so that switching branches is detected as a change.  Changes to other files in
+// the git directory are ignored.
+func WithGitHead(gitHead bool) watcherOption {
+	return func(w *watcher) {
+		w.gitHead = gitHead
+	}
+}
+
+// WithContentHash configures whether changes are only processed if they alter the content of at
+// least one file, as determined by comparing hashes of the files' content.  This ignores saves that
+// rewrite a file with identical content.  Events other than writes always count as changes.
+func WithContentHash(contentHash bool) watcherOption {
+	return func(w *watcher) {
+		w.contentHash = contentHash
+	}
+}
+
+// WithExtraFiles configures files watched in addition to the resolved dependencies, such as the
+// environment file of the command, so that changes to them trigger a rerun too.
+func WithExtraFiles(paths []string) watcherOption {
+	return func(w *watcher) {
+		w.extraFiles = paths
+	}
+}
+
+// WithPreviousCount configures the number of files watched by the watcher this one replaces, such
+// as the one of the previous monitoring cycle.  The number of files watched is then only logged at
+// info level if it differs, to avoid repeating it on every rerun.
+func WithPreviousCount(count int) watcherOption {
+	return func(w *watcher) {
+		w.previousCount = count
+	}
+}
+
+// WithSaveOnly configures whether only saved files count as changes, collapsing the sequences of
+// events editors produce when saving a file into a single change.  Events on the temporary files
+// editors create are ignored, and changes are only processed once the debounce delay elapses if at
+// least one file was saved, as determined by isSave.
+func WithSaveOnly(saveOnly bool) watcherOption {
+	return func(w *watcher) {
+		w.saveOnly = saveOnly
+	}
+}
+
+// WithIgnoreRemoves configures whether the removal of files is ignored rather than treated as a
+// change.
+func WithIgnoreRemoves(ignore bool) watcherOption {
+	return func(w *watcher) {
+		w.ignoreRemoves = ignore
+	}
+}
+
+// WithManifest configures a manifest listing the paths to watch, one per line, which are watched
+// instead of resolving dependencies.  An empty path resolves dependencies as usual.
+func WithManifest(path string) watcherOption {
+	return func(w *watcher) {
+		w.manifest = path
+	}
+}
+
+// WithPolling configures the interval at which watched directories are scanned for changes, instead
+// of relying on file system events, which some file systems do not deliver.  Zero relies on file
+// system events.
+func WithPolling(interval time.Duration) watcherOption {
+	return func(w *watcher) {
+		w.pollInterval = interval
+	}
+}
+
+// WithResolveInterval configures the interval at which dependencies are re-resolved in the
+// background and the watch set reconciled with the result, regardless of events, as a safety net
+// for changes to the dependencies that go unnoticed.  Zero disables periodic re-resolution.
+func WithResolveInterval(interval time.Duration) watcherOption {
+	return func(w *watcher) {
+		w.resolveEvery = interval
+	}
+}
+
+// withClock replaces the clock used to schedule debounce timers.  It exists so that tests can drive
+// the debounce window without resorting to real sleeps.
+func withClock(c clock) watcherOption {
+	return func(w *watcher) {
+		w.clock = c
+	}
+}
+
+// Watch starts the watcher on the specified path and returns once all dependencies are being
+// watched.  Changes are reported through the channel returned by Wait until the watcher is closed
+// or the context is cancelled.  Directories that no longer exist by the time they are added are
+// skipped, as long as at least one directory could be added.  It returns an error if the watcher is
+// already running or fails to start.
+func (w *watcher) Watch(ctx context.Context, path string) error {
 	if w.watcher != nil {
 		return &WatcherAlreadyRunningError{}
 	}
 
-	w.done = make(chan error)
-
-	watcher, err := fsnotify.NewWatcher()
+	watcher, err := w.newFSWatcher()
 	if err != nil {
 		return &WatcherCreationError{Err: err}
 	}
 	w.watcher = watcher
 
-	walker := NewDepWalker(flags.includeExternalDeps)
-	deps, err := walker.List(path)
+	deps, err := w.resolve(path)
 	if err != nil {
-		return &WatcherDepWalkerError{Err: err}
+		return err
 	}
 
+	w.path = path
+	w.deps = make(map[string]struct{}, len(deps))
 	for _, p := range deps {
-		err = watcher.Add(p)
-		if err != nil {
-			return &PathAdditionError{Path: p, Err: err}
+		w.deps[p] = struct{}{}
+	}
+
+	dirs := watchDirs(deps)
+	w.dirs = make(map[string]struct{}, len(dirs))
+	start := time.Now()
+	var skipped int
+	var firstErr error
+	for i, d := range sortedKeys(dirs) {
+		err = watcher.Add(d)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return &PathAdditionError{Path: d, Err: err}
+		} else if err != nil {
+			// The directory may have been removed since dependencies were resolved, e.g.
+			// by a concurrent git operation.
+			log.Warn().Msgf("skipping directory that no longer exists: %s", d)
+			if firstErr == nil {
+				firstErr = &PathAdditionError{Path: d, Err: err}
+			}
+			skipped++
+			continue
+		}
+		w.dirs[d] = struct{}{}
+
+		if n := i + 1; n%addProgressInterval == 0 {
+			log.Debug().Msgf("added %d of %d directories to watcher (%d%%)", n, len(dirs),
+				n*100/len(dirs))
 		}
 	}
 
-	log.Info().Msgf("watching %d files...", len(deps))
-	go w.monitor()
+	if skipped > 0 && len(w.dirs) == 0 {
+		return firstErr
+	} else if skipped > 0 {
+		log.Warn().Msgf("skipped %d of %d directories that no longer exist", skipped, len(dirs))
+	}
+	log.Debug().Msgf("added %d directories to watcher in %s", len(w.dirs), time.Since(start))
+
+	if w.contentHash {
+		w.hashes = make(map[string]string, len(deps))
+		w.hashAll(deps)
+	}
+
+	if w.gitHead {
+		w.watchGitDir(path)
+	}
 
-	// Blocking until the first event comes through.
-	if err = <-w.done; err != nil {
+	if len(deps) != w.previousCount {
+		log.Info().Msgf("watching %d files...", len(deps))
+	} else {
+		log.Debug().Msgf("watching %d files...", len(deps))
+	}
+	if len(deps) == 0 {
+		warnNothingWatched(path)
+	}
+	w.startedAt = w.clock.Now()
+	go w.monitor(ctx, watcher)
+	if w.resolveEvery > 0 {
+		go w.resolvePeriodically(ctx)
+	}
+
+	return nil
+}
+
+// Refresh re-resolves the dependencies of the watched path and reconciles the watch set with the
+// result, adding new paths and removing those that are no longer dependencies.  Directories that no
+// longer exist by the time they are added are skipped.  It is a no-op if the watcher is not
+// running.
+func (w *watcher) Refresh() error {
+	return w.refresh(false)
+}
+
+// resolvePeriodically refreshes the watch set at the configured interval until the context is
+// cancelled or the watcher is closed.
+func (w *watcher) resolvePeriodically(ctx context.Context) {
+	ticker := time.NewTicker(w.resolveEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		closed := w.closed
+		w.mu.Unlock()
+		if closed {
+			return
+		}
+
+		log.Trace().Msg("re-resolving dependencies periodically")
+		if err := w.refresh(true); err != nil {
+			log.Warn().Msgf("unable to re-resolve dependencies: %v", err)
+		}
+	}
+}
+
+// refresh implements Refresh.  A quiet refresh only logs the number of files watched if the watch
+// set changed.
+func (w *watcher) refresh(quiet bool) error {
+	w.mu.Lock()
+	path := w.path
+	running := w.watcher != nil && !w.closed
+	w.mu.Unlock()
+
+	if !running {
+		log.Trace().Msg("not refreshing watcher: not running")
+		return nil
+	}
+
+	deps, err := w.resolve(path)
+	if err != nil {
 		return err
 	}
 
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.watcher == nil || w.closed {
+		return nil
+	}
+
+	var added, removed []string
+	next := make(map[string]struct{}, len(deps))
+	for _, p := range deps {
+		next[p] = struct{}{}
+		if _, ok := w.deps[p]; !ok {
+			added = append(added, p)
+		}
+	}
+	for p := range w.deps {
+		if _, ok := next[p]; !ok {
+			removed = append(removed, p)
+		}
+	}
+	sort.Strings(removed)
+
+	dirs := watchDirs(deps)
+	for _, d := range sortedKeys(dirs) {
+		if _, ok := w.dirs[d]; ok {
+			continue
+		}
+
+		if err := w.watcher.Add(d); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return &PathAdditionError{Path: d, Err: err}
+		} else if err != nil {
+			log.Warn().Msgf("skipping directory that no longer exists: %s", d)
+			continue
+		}
+		w.dirs[d] = struct{}{}
+	}
+	for d := range w.dirs {
+		if _, ok := dirs[d]; ok {
+			continue
+		}
+
+		// The directory may no longer exist, in which case it has already been removed.
+		if err := w.watcher.Remove(d); err != nil {
+			log.Trace().Msgf("error removing directory from watcher: %s: %v", d, err)
+		}
+		delete(w.dirs, d)
+	}
+
+	if w.contentHash {
+		w.hashAll(added)
+		for _, p := range removed {
+			delete(w.hashes, p)
+		}
+	}
+
+	w.deps = next
+	if len(deps) == 0 {
+		warnNothingWatched(path)
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		// The count is unchanged, and was logged at info level when it last changed.
+		if !quiet {
+			log.Debug().Msgf("watching %d files...", len(deps))
+		}
+		return nil
+	}
+
+	log.Info().Msgf("watching %d files (%d added, %d removed)...", len(deps), len(added), len(removed))
+	for _, p := range added {
+		log.Debug().Msgf("watch added: %s", p)
+	}
+	for _, p := range removed {
+		log.Debug().Msgf("watch removed: %s", p)
+	}
 	return nil
 }
 
@@ -155,16 +524,60 @@ func (w *watcher) Close() error {
 	return tw.Close()
 }
 
-// Wait returns a channel that is closed when the watcher stops watching.
+// Count returns the number of paths currently watched.
+func (w *watcher) Count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.deps)
+}
+
+// Changes returns the sorted paths of the files affected by the last change detected, which may
+// span several events coalesced by debouncing.
+func (w *watcher) Changes() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return slices.Clone(w.changes)
+}
+
+// Wait returns a channel that receives a value once a change has been detected and is closed when
+// the watcher stops watching.
 func (w *watcher) Wait() chan error {
 	return w.done
 }
 
-// monitor starts the event monitoring loop, processing file system events.
-func (w *watcher) monitor() {
+// newFSWatcher creates the source of file system events: a polling watcher if a poll interval is
+// configured, and an fsnotify watcher otherwise.
+func (w *watcher) newFSWatcher() (fsWatcher, error) {
+	if w.pollInterval > 0 {
+		log.Debug().Msgf("polling for changes every %s", w.pollInterval)
+		return newPollWatcher(w.pollInterval), nil
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return notifyWatcher{fsw}, nil
+}
+
+// monitor starts the event monitoring loop, processing file system events until the context is
+// cancelled.  The source of events is passed in explicitly so that the loop is unaffected by Close
+// resetting the instance's field.
+func (w *watcher) monitor(ctx context.Context, fsw fsWatcher) {
+	var events <-chan fsnotify.Event = fsw.Events()
+	if w.eventBuffer > 0 {
+		events = bufferEvents(ctx, fsw.Events(), w.eventBuffer)
+	}
+
 	for {
 		select {
-		case err, ok := <-w.watcher.Errors:
+		case <-ctx.Done():
+			log.Trace().Msg("context cancelled, no longer monitoring events")
+			return
+
+		case err, ok := <-fsw.Errors():
 			if !ok {
 				log.Trace().Msg("watcher error received but channel closed")
 				w.end(nil)
@@ -172,52 +585,352 @@ func (w *watcher) monitor() {
 			}
 			log.Error().Msgf("error occurred while watching files: %v", err)
 
-		case e, ok := <-w.watcher.Events:
+		case e, ok := <-events:
 			if !ok {
 				log.Warn().Msg("event received but channel closed")
 				w.end(nil)
 				return
 			}
 
-			// FIXME: must pass (or determine) the containing directories of every
-			//	  package so that the Create event works.
 			if !e.Has(fsnotify.Create) && !e.Has(fsnotify.Remove) &&
 				!e.Has(fsnotify.Write) {
 				log.Trace().Msgf("ignoring event: %s %s", e.Op.String(), e.Name)
 				continue
 			}
 
-			log.Trace().Msgf("processing event: %s %s", e.Op.String(), e.Name)
-			w.syncRun(func() {
-				if w.timer != nil {
-					w.stopTimer()
+			if w.gitDir != "" && filepath.Dir(e.Name) == w.gitDir {
+				if filepath.Base(e.Name) != "HEAD" {
+					log.Trace().Msgf("ignoring event in git directory: %s %s",
+						e.Op.String(), e.Name)
+					continue
 				}
+				log.Debug().Msg("git HEAD changed")
+			} else if !w.isRelevant(e) {
+				log.Trace().Msgf("ignoring event on non-dependency: %s %s", e.Op.String(),
+					e.Name)
+				continue
+			} else if w.goFilesOnly && !w.isGoDep(e.Name) {
+				log.Trace().Msgf("ignoring event on non-Go dependency: %s %s", e.Op.String(),
+					e.Name)
+				continue
+			} else if w.saveOnly && isEditorTempFile(e.Name) {
+				log.Trace().Msgf("ignoring event on editor temporary file: %s %s",
+					e.Op.String(), e.Name)
+				continue
+			}
 
-				log.Trace().Msgf("setting up timer")
-				w.timer = time.AfterFunc(w.debounceDelay, func() {
-					w.syncRun(func() {
-						w.process(e)
-					})
+			// fsnotify drops the watch of a removed directory, so forget it for Refresh
+			// to add it again should it be recreated.
+			if e.Has(fsnotify.Remove) {
+				w.syncRun(func() {
+					delete(w.dirs, e.Name)
 				})
+
+				if w.ignoreRemoves && e.Op&^fsnotify.Chmod == fsnotify.Remove {
+					log.Trace().Msgf("ignoring removal: %s", e.Name)
+					continue
+				}
+			}
+
+			if elapsed := w.clock.Now().Sub(w.startedAt); elapsed < w.warmup {
+				log.Trace().Msgf("ignoring event during warm-up: %s %s", e.Op.String(),
+					e.Name)
+				continue
+			}
+
+			if w.ignore != nil && w.ignore(e.Name) {
+				log.Trace().Msgf("ignoring event: %s %s", e.Op.String(), e.Name)
+				continue
+			}
+
+			log.Trace().Msgf("processing event: %s %s", e.Op.String(), e.Name)
+			w.syncRun(func() {
+				w.schedule(e)
 			})
 		}
 	}
 }
 
-// process handles a single file system event.
+// watchGitDir adds the git directory of the repository containing the given path to the watcher.
+// The directory rather than the HEAD file itself is watched since git replaces HEAD by renaming a
+// new file over it.  Failure is logged rather than returned, as monitoring can proceed without it.
+func (w *watcher) watchGitDir(path string) {
+	gitDir, err := FindGitDir(path)
+	if err != nil {
+		log.Warn().Msgf("not watching git HEAD: %v", err)
+		return
+	}
+
+	if err := w.watcher.Add(gitDir); err != nil {
+		log.Warn().Msgf("not watching git HEAD: %v", &PathAdditionError{Path: gitDir, Err: err})
+		return
+	}
+
+	w.gitDir = gitDir
+	log.Debug().Msgf("watching git HEAD in %s", gitDir)
+}
+
+// warnNothingWatched warns that no dependencies were found for the given path, in which case the
+// command is run but never restarted.
+func warnNothingWatched(path string) {
+	log.Warn().Msgf("no files are being watched in %s, so the program will not be restarted: "+
+		"check the path and the --pattern, --include-tests and --ignore-dir settings", path)
+}
+
+// isRelevant reports whether the event concerns a dependency or a path beneath a dependency that
+// is a directory, such as a testdata directory.  The creation of a source file in a watched
+// directory is also relevant, since the file may be added to a package.  Such a file is added to
+// the dependencies at once, so that changes made to it before they are next resolved are not
+// missed.
+func (w *watcher) isRelevant(e fsnotify.Event) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.deps[e.Name]; ok {
+		return true
+	} else if _, ok := w.deps[filepath.Dir(e.Name)]; ok {
+		return true
+	}
+
+	// Only the exact paths listed in a manifest are of interest.
+	if w.manifest != "" || !e.Has(fsnotify.Create) || !w.isSourceFile(e.Name) {
+		return false
+	}
+
+	log.Debug().Msgf("watch added: %s", e.Name)
+	w.deps[e.Name] = struct{}{}
+	return true
+}
+
+// isSourceFile reports whether the given path names a Go source file or a file with one of the
+// additional extensions included by the dependency walker.
+func (w *watcher) isSourceFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".go" || slices.Contains(w.walker.extensions, ext)
+}
+
+// watchDirs returns the set of directories to watch for the given dependencies: the directory
+// containing every file, along with every dependency that is itself a directory.  Watching
+// directories rather than individual files uses fewer watch descriptors and allows the creation of
+// files to be noticed.
+func watchDirs(deps Deps) map[string]struct{} {
+	dirs := make(map[string]struct{})
+	for _, p := range deps {
+		if stat, err := os.Stat(p); err == nil && stat.IsDir() {
+			dirs[p] = struct{}{}
+		} else {
+			dirs[filepath.Dir(p)] = struct{}{}
+		}
+	}
+
+	return dirs
+}
+
+// sortedKeys returns the keys of the given set in sorted order.
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// isGoDep reports whether the given path is a Go source file in the resolved dependencies.
+func (w *watcher) isGoDep(path string) bool {
+	if !strings.HasSuffix(path, ".go") {
+		return false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, ok := w.deps[path]
+	return ok
+}
+
+// bufferEvents forwards the events read from in to the returned buffered channel, which is closed
+// once in is closed or the context is cancelled.  A warning is logged when the buffer fills up,
+// in which case reading blocks until room is made.
+func bufferEvents(ctx context.Context, in <-chan fsnotify.Event, size int) <-chan fsnotify.Event {
+	out := make(chan fsnotify.Event, size)
+	go func() {
+		defer close(out)
+
+		// The warning is only repeated once the buffer has had room again, to avoid flooding
+		// the log during a sustained burst.
+		full := false
+		for {
+			var e fsnotify.Event
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-in:
+				if !ok {
+					return
+				}
+				e = ev
+			}
+
+			select {
+			case out <- e:
+				full = false
+				continue
+			default:
+			}
+
+			if !full {
+				full = true
+				log.Warn().Msgf("event buffer full (%d events), processing is falling behind", size)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- e:
+			}
+		}
+	}()
+
+	return out
+}
+
+// schedule (re)starts the debounce timer that processes the event once it elapses.  In per-file
+// mode only the timer associated with the event's file is restarted.  The event is processed at
+// once if debouncing is disabled.  Must be called with the watcher's mutex held.
+func (w *watcher) schedule(e fsnotify.Event) {
+	if w.pending == nil {
+		w.pending = make(map[string]fsnotify.Op)
+	}
+	w.pending[e.Name] |= e.Op
+
+	if w.debounceDelay <= 0 {
+		w.process(e)
+		return
+	}
+
+	fire := func() {
+		w.syncRun(func() {
+			w.process(e)
+		})
+	}
+
+	if !w.perFile {
+		if w.timer != nil {
+			w.stopTimer()
+		}
+
+		log.Trace().Msgf("setting up timer")
+		w.timer = w.clock.AfterFunc(w.debounceDelay, fire)
+		return
+	}
+
+	if t, ok := w.fileTimers[e.Name]; ok {
+		t.Stop()
+	} else if w.fileTimers == nil {
+		w.fileTimers = make(map[string]timer)
+	}
+
+	log.Trace().Msgf("setting up timer for %s", e.Name)
+	w.fileTimers[e.Name] = w.clock.AfterFunc(w.debounceDelay, fire)
+}
+
+// process handles a single file system event, along with the changes pending alongside it.  It is
+// a no-op if no changes are pending, as happens when a per-file timer fires while the batch it
+// belonged to is being processed, too late to be stopped.
 func (w *watcher) process(e fsnotify.Event) {
+	if len(w.pending) == 0 {
+		log.Trace().Msgf("not processing %s %s: no changes pending", e.Op.String(), e.Name)
+		return
+	}
+
+	if w.saveOnly && !w.pruneUnsaved() {
+		log.Info().Msg("ignoring changes: no file saved")
+		w.pending = nil
+		w.stopTimer()
+		return
+	}
+
+	changes := make([]string, 0, len(w.pending))
+	for p := range w.pending {
+		changes = append(changes, p)
+	}
+	sort.Strings(changes)
+
+	if w.contentHash && !w.contentChanged() {
+		log.Info().Msg("ignoring changes: content unchanged")
+		w.stopTimer()
+		return
+	}
+
 	log.Info().Msgf("%s %s", e.Op.String(), e.Name)
+	w.pending = nil
+	w.changes = changes
 	w.stopTimer()
 	w.end(nil)
 }
 
-// stopTimer stops the debounce timer if it is running.
+// pruneUnsaved discards the pending changes that do not amount to a file being saved, reporting
+// whether any remain.  Must be called with the watcher's mutex held.
+func (w *watcher) pruneUnsaved() bool {
+	for p, op := range w.pending {
+		if !isSave(p, op) {
+			log.Debug().Msgf("not a save: %s %s", op.String(), p)
+			delete(w.pending, p)
+		}
+	}
+
+	return len(w.pending) > 0
+}
+
+// contentChanged reports whether any of the pending changes altered the content of a file, updating
+// the recorded hashes and clearing the pending changes.  Events other than writes, and writes to
+// files whose content cannot be hashed, count as changes.  Must be called with the watcher's mutex
+// held.
+func (w *watcher) contentChanged() bool {
+	changed := false
+	for p, op := range w.pending {
+		hash, err := hashFile(p)
+		if err != nil {
+			log.Trace().Msgf("unable to hash file content: %s: %v", p, err)
+			delete(w.hashes, p)
+			changed = true
+			continue
+		}
+
+		if op&^fsnotify.Chmod != fsnotify.Write || hash != w.hashes[p] {
+			changed = true
+		}
+		w.hashes[p] = hash
+	}
+
+	w.pending = nil
+	return changed
+}
+
+// hashAll records the hashes of the content of the given paths.  Paths that cannot be hashed, such
+// as directories, are skipped.
+func (w *watcher) hashAll(paths []string) {
+	for _, p := range paths {
+		if hash, err := hashFile(p); err == nil {
+			w.hashes[p] = hash
+		}
+	}
+}
+
+// stopTimer stops the debounce timer if it is running, along with any per-file timers, since a
+// change being processed accounts for all the changes pending at the time.
 func (w *watcher) stopTimer() {
 	if w.timer != nil {
 		log.Debug().Msg("stopping timer")
 		w.timer.Stop()
 		w.timer = nil
 	}
+
+	for name, t := range w.fileTimers {
+		t.Stop()
+		delete(w.fileTimers, name)
+	}
 }
 
 // end signals the completion of event processing, optionally with an error.
@@ -247,3 +960,53 @@ func (w *watcher) syncRun(f func()) {
 
 	f()
 }
+
+// resolve determines the paths to be watched for the given path.
+func (w *watcher) resolve(path string) (Deps, error) {
+	var deps Deps
+	var err error
+	if w.manifest != "" {
+		if deps, err = readManifest(w.manifest); err != nil {
+			return nil, err
+		}
+	} else if deps, err = w.walker.List(path); err != nil {
+		return nil, &WatcherDepWalkerError{Err: err}
+	}
+	if len(w.extraFiles) > 0 {
+		deps = dedupeSorted(append(deps, w.extraFiles...))
+	}
+
+	if w.followSymlinks {
+		deps = resolveSymlinks(deps)
+	}
+
+	return deps, nil
+}
+
+// resolveSymlinks returns a copy of deps in which every path has been replaced by the target it
+// resolves to.  Paths that cannot be resolved, such as broken symlinks, are logged and skipped.
+// Duplicates arising from multiple links to the same target are removed.
+func resolveSymlinks(deps Deps) Deps {
+	seen := make(map[string]struct{}, len(deps))
+	resolved := make(Deps, 0, len(deps))
+	for _, p := range deps {
+		target, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			log.Warn().Msgf("not watching unresolvable path: %s: %v", p, err)
+			continue
+		}
+
+		if target != p {
+			log.Debug().Msgf("resolved symlink: %s -> %s", p, target)
+		}
+
+		if _, ok := seen[target]; ok {
+			continue
+		}
+
+		seen[target] = struct{}{}
+		resolved = append(resolved, target)
+	}
+
+	return resolved
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fakeClock implements clock with a time that only advances when told to, firing the timers that
+// become due synchronously.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// fakeTimer is a timer scheduled on a fakeClock.
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the time forward by d and fires the timers that are due, in order.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+
+	for _, f := range c.due() {
+		f()
+	}
+}
+
+// Pending returns the number of timers that are scheduled and have not been stopped.
+func (c *fakeClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, t := range c.timers {
+		if !t.stopped {
+			n++
+		}
+	}
+	return n
+}
+
+// due removes the timers that are due from the clock and returns their functions, in order.
+func (c *fakeClock) due() []func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var fs []func()
+	var pending []*fakeTimer
+	for _, t := range c.timers {
+		if t.stopped {
+			continue
+		} else if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.stopped = true
+		fs = append(fs, t.f)
+	}
+	c.timers = pending
+	return fs
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	if t.stopped {
+		return false
+	}
+	t.stopped = true
+	return true
+}
+
+// send delivers an event to the watcher as the monitoring loop does once it has been filtered.
+func send(w *watcher, op fsnotify.Op, name string) {
+	w.syncRun(func() {
+		w.schedule(fsnotify.Event{Name: name, Op: op})
+	})
+}
+
+// received reports whether a change has been signalled on the watcher's channel, consuming it.
+func received(w *watcher) bool {
+	select {
+	case <-w.Wait():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestWatcherCoalescesEvents(t *testing.T) {
+	clk := newFakeClock()
+	w := NewWatcher(WithDelay(100*time.Millisecond), withClock(clk))
+
+	send(w, fsnotify.Write, "/p/a.go")
+	clk.Advance(60 * time.Millisecond)
+	send(w, fsnotify.Write, "/p/b.go")
+	clk.Advance(60 * time.Millisecond)
+	if received(w) {
+		t.Fatal("change signalled before the debounce window elapsed since the last event")
+	}
+
+	clk.Advance(40 * time.Millisecond)
+	if !received(w) {
+		t.Fatal("no change signalled once the debounce window elapsed")
+	}
+	if got, want := w.Changes(), []string{"/p/a.go", "/p/b.go"}; !slices.Equal(got, want) {
+		t.Errorf("Changes() = %v, want %v", got, want)
+	}
+
+	clk.Advance(time.Second)
+	if received(w) {
+		t.Error("coalesced events signalled more than once")
+	}
+}
+
+func TestWatcherZeroDelay(t *testing.T) {
+	clk := newFakeClock()
+	w := NewWatcher(WithDelay(0), withClock(clk))
+
+	send(w, fsnotify.Write, "/p/a.go")
+	if !received(w) {
+		t.Fatal("event not processed at once")
+	}
+	if n := clk.Pending(); n != 0 {
+		t.Errorf("%d timers scheduled, want none", n)
+	}
+	if got, want := w.Changes(), []string{"/p/a.go"}; !slices.Equal(got, want) {
+		t.Errorf("Changes() = %v, want %v", got, want)
+	}
+
+	send(w, fsnotify.Create, "/p/b.go")
+	if !received(w) {
+		t.Fatal("second event not processed at once")
+	}
+	if got, want := w.Changes(), []string{"/p/b.go"}; !slices.Equal(got, want) {
+		t.Errorf("Changes() = %v, want %v", got, want)
+	}
+}
+
+func TestWatcherPerFileDebounce(t *testing.T) {
+	clk := newFakeClock()
+	w := NewWatcher(WithDelay(100*time.Millisecond), WithPerFileDebounce(true), withClock(clk))
+
+	send(w, fsnotify.Write, "/p/a.go")
+	send(w, fsnotify.Write, "/p/b.go")
+	if n := clk.Pending(); n != 2 {
+		t.Fatalf("%d timers scheduled, want one per file", n)
+	}
+
+	// Events on one file only postpone that file's timer, so the other's still fires on time.
+	clk.Advance(60 * time.Millisecond)
+	send(w, fsnotify.Write, "/p/a.go")
+	clk.Advance(40 * time.Millisecond)
+	if !received(w) {
+		t.Fatal("no change signalled once the window of the quiet file elapsed")
+	}
+	if got, want := w.Changes(), []string{"/p/a.go", "/p/b.go"}; !slices.Equal(got, want) {
+		t.Errorf("Changes() = %v, want %v", got, want)
+	}
+
+	// Processing accounts for all pending changes, so the remaining timer is stopped.
+	if n := clk.Pending(); n != 0 {
+		t.Errorf("%d timers still scheduled, want none", n)
+	}
+	clk.Advance(time.Second)
+	if received(w) {
+		t.Error("change signalled again for the same batch")
+	}
+}
+
+func TestWatcherPerFileTimersFiringTogether(t *testing.T) {
+	clk := newFakeClock()
+	w := NewWatcher(WithDelay(100*time.Millisecond), WithPerFileDebounce(true), withClock(clk))
+
+	send(w, fsnotify.Write, "/p/a.go")
+	send(w, fsnotify.Write, "/p/b.go")
+
+	// Both timers fire before either acquires the watcher's mutex, so that processing the first
+	// is too late to stop the second.
+	clk.mu.Lock()
+	clk.now = clk.now.Add(100 * time.Millisecond)
+	clk.mu.Unlock()
+	fired := clk.due()
+	if len(fired) != 2 {
+		t.Fatalf("got %d timers due, want 2", len(fired))
+	}
+
+	fired[0]()
+	if !received(w) {
+		t.Fatal("no change signalled")
+	}
+
+	fired[1]()
+	if received(w) {
+		t.Error("second change signalled for the same batch")
+	}
+	if got, want := w.Changes(), []string{"/p/a.go", "/p/b.go"}; !slices.Equal(got, want) {
+		t.Errorf("Changes() = %v, want %v", got, want)
+	}
+}
+
+func TestWatcherNewSourceFile(t *testing.T) {
+	w, dir := watchModule(t)
+
+	added := filepath.Join(dir, "util.go")
+	if watched(w, added) {
+		t.Fatalf("%s watched before being created", added)
+	}
+	if err := os.WriteFile(added, []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	waitChange(t, w, added)
+
+	if err := w.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if !watched(w, added) {
+		t.Errorf("%s not watched after refreshing", added)
+	}
+}
+
+func TestWatcherNewSourceFileEdited(t *testing.T) {
+	w, dir := watchModule(t)
+
+	// The file is created empty, so that its creation and its first edit are reported apart.
+	added := filepath.Join(dir, "util.go")
+	f, err := os.Create(added)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	waitChange(t, w, added)
+	if !watched(w, added) {
+		t.Fatalf("%s not watched once created", added)
+	}
+
+	// The edit is noticed without the dependencies having been resolved again.
+	if err := os.WriteFile(added, []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitChange(t, w, added)
+}
+
+// watchModule creates a module made of a main package in a temporary directory, and returns a
+// watcher watching it along with the directory.
+func watchModule(t *testing.T) (*watcher, string) {
+	t.Helper()
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"go.mod":  "module example.com/m\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	w := NewWatcher(WithDelay(10 * time.Millisecond))
+	if err := w.Watch(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w, dir
+}
+
+// waitChange waits for a change to be signalled on the watcher, failing the test unless it
+// includes the given path.
+func waitChange(t *testing.T, w *watcher, path string) {
+	t.Helper()
+
+	select {
+	case <-w.Wait():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("no change signalled for %s", path)
+	}
+	if changes := w.Changes(); !slices.Contains(changes, path) {
+		t.Errorf("Changes() = %v, want it to contain %s", changes, path)
+	}
+}
+
+// watched reports whether the given path is among the watcher's dependencies.
+func watched(w *watcher, path string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, ok := w.deps[path]
+	return ok
+}
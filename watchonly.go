@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// watchOnlyCmd defines the command that logs changes to the monitored files without running any
+// command.
+var watchOnlyCmd = &cobra.Command{
+	Use:   "watch-only [flags] [path]",
+	Short: "Print changes to the monitored files without running any command.",
+	Long: `Watches the files that would be monitored for PATH and prints every change detected, once debounced, to standard output, without ever running a command.  Each line holds the time of the change followed by the files affected by it.  The dependencies are resolved again after every change, so files that become dependencies are watched too.  The flags determining which files are watched and how changes are detected apply as they do to the root command.
+
+If PATH is not specified, the current working directory is assumed.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  runWatchOnly,
+}
+
+// init registers the watch-only command with the root command.
+func init() {
+	rootCmd.AddCommand(watchOnlyCmd)
+}
+
+// runWatchOnly is the main execution logic of the watch-only command.
+func runWatchOnly(cmd *cobra.Command, args []string) {
+	path := "."
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	if flags.debounce < 0 {
+		Fatal("--debounce cannot be negative")
+	}
+
+	ctx, stop, _ := shutdownContext()
+	defer stop()
+
+	watcher := NewWatcher(watcherOptions(path, nil)...)
+	defer watcher.Close()
+	if err := watcher.Watch(ctx, path); err != nil {
+		Fatal(err.Error())
+	}
+
+	for {
+		select {
+		case err := <-watcher.Wait():
+			if err != nil {
+				Fatal(err.Error())
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		fmt.Printf("%s %s\n", time.Now().Format(time.RFC3339), strings.Join(watcher.Changes(), " "))
+		if err := watcher.Refresh(); err != nil {
+			Fatal(err.Error())
+		}
+	}
+}